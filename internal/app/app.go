@@ -4,8 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/spf13/cobra"
 	"maibot/internal/config"
@@ -22,37 +24,62 @@ const (
 
 type App struct {
 	cfg         config.Config
+	cfgLoaded   config.Loaded
+	logOptions  logging.Options
 	i18n        *localizer
 	log         *logging.Logger
 	instanceLog *logging.Logger
 	updateLog   *logging.Logger
 	cleanupLog  *logging.Logger
 	modulesLog  *logging.Logger
+	gitLog      *logging.Logger
+	output      string
 }
 
 func New() (*App, error) {
-	cfg, err := config.LoadOrCreate()
-	if err != nil {
+	a := &App{}
+	if err := a.loadConfig(); err != nil {
 		return nil, err
 	}
-	rootLog, err := logging.NewRoot(logging.Options{
+	return a, nil
+}
+
+// loadConfig loads the effective config, including the layer of the
+// workspace around the current directory, and sets up logging and messages
+// from it. It runs again once -C has changed the directory.
+func (a *App) loadConfig() error {
+	workspaceDir, found, _ := detectWorkspaceDir()
+	if !found {
+		workspaceDir = ""
+	}
+	loaded, err := config.Load(workspaceDir)
+	if err != nil {
+		return err
+	}
+	cfg := loaded.Config
+	opts := logging.Options{
 		FilePath:       cfg.Logging.FilePath,
 		MaxSizeMB:      cfg.Logging.MaxSizeMB,
 		RetentionDays:  cfg.Logging.RetentionDays,
 		MaxBackupFiles: cfg.Logging.MaxBackupFiles,
-	})
-	if err != nil {
-		return nil, err
 	}
-	return &App{
-		cfg:         cfg,
-		i18n:        newLocalizer(cfg.Installer.Language),
-		log:         rootLog.Module("app"),
-		instanceLog: rootLog.Module("instance"),
-		updateLog:   rootLog.Module("update"),
-		cleanupLog:  rootLog.Module("cleanup"),
-		modulesLog:  rootLog.Module("modules"),
-	}, nil
+	if a.log == nil || opts != a.logOptions {
+		rootLog, err := logging.NewRoot(opts)
+		if err != nil {
+			return err
+		}
+		a.logOptions = opts
+		a.log = rootLog.Module("app")
+		a.instanceLog = rootLog.Module("instance")
+		a.updateLog = rootLog.Module("update")
+		a.cleanupLog = rootLog.Module("cleanup")
+		a.modulesLog = rootLog.Module("modules")
+		a.gitLog = rootLog.Module("git")
+	}
+	a.cfg = cfg
+	a.cfgLoaded = loaded
+	a.i18n = newLocalizer(cfg.Installer.Language)
+	return nil
 }
 
 func (a *App) Run(args []string) {
@@ -62,9 +89,6 @@ func (a *App) Run(args []string) {
 }
 
 func (a *App) Execute(args []string) error {
-	if err := a.validateConfig(); err != nil {
-		return errors.New(a.tf("err.invalid_config", err))
-	}
 	cmd := a.newRootCommand()
 	cmd.SetArgs(args)
 	if err := cmd.Execute(); err != nil {
@@ -84,34 +108,50 @@ func (a *App) newRootCommand() *cobra.Command {
 		},
 	}
 
-	var chdirPath string
+	var chdirPath, output string
 	root.PersistentFlags().StringVarP(&chdirPath, "directory", "C", "", "Run as if maibot was started in this path")
+	root.PersistentFlags().StringVarP(&output, "output", "o", outputTable, "Output format for read-only commands: table, json or yaml")
 	root.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
-		if strings.TrimSpace(chdirPath) == "" {
-			return nil
-		}
-		abs, err := filepath.Abs(chdirPath)
-		if err != nil {
+		if err := a.setOutput(output); err != nil {
 			return err
 		}
-		if st, err := os.Stat(abs); err != nil {
+		if err := a.chdir(chdirPath); err != nil {
 			return err
-		} else if !st.IsDir() {
-			return errors.New(a.tf("err.chdir_not_directory", abs))
 		}
-		return os.Chdir(abs)
+		if m := a.cfgLoaded.Migration; m != nil && !m.Pinned && !configHistoryCommands[cmd.CommandPath()] {
+			if err := a.migrateConfig(); err != nil {
+				return err
+			}
+		}
+		if lifecycleCommands[cmd.CommandPath()] {
+			return a.requireValidConfig()
+		}
+		return nil
 	}
 
-	root.AddCommand(&cobra.Command{Use: "init", Aliases: []string{"install", "create"}, Args: cobra.NoArgs, RunE: func(cmd *cobra.Command, args []string) error {
-		if err := a.installInstance(defaultName); err != nil {
+	initCmd := &cobra.Command{Use: "init", Aliases: []string{"install", "create"}, Args: cobra.NoArgs, RunE: func(cmd *cobra.Command, args []string) error {
+		repo, _ := cmd.Flags().GetString("repo")
+		ref, _ := cmd.Flags().GetString("ref")
+		noClone, _ := cmd.Flags().GetBool("no-clone")
+		tags, _ := cmd.Flags().GetStringArray("tag")
+		err := a.lockedInitWorkspace(func() error {
+			return a.installInstance(cmd.Context(), defaultName, initOptions{repo: repo, ref: ref, noClone: noClone, tags: tags})
+		})
+		if err != nil {
 			return err
 		}
 		a.instanceLog.Okf(a.t("log.workspace_initialized"))
 		return nil
-	}})
+	}}
+	initCmd.Flags().String("repo", "", "MaiBot repository URL (default maibot.repo_url)")
+	initCmd.Flags().String("ref", "", "MaiBot branch or tag to check out (default maibot.ref)")
+	initCmd.Flags().Bool("no-clone", false, "Only create the workspace layout, skip cloning MaiBot")
+	initCmd.Flags().StringArray("tag", nil, "Tag the workspace in the registry (repeatable)")
+	root.AddCommand(initCmd)
 
 	root.AddCommand(&cobra.Command{Use: "start", Args: cobra.NoArgs, RunE: func(cmd *cobra.Command, args []string) error {
-		if err := a.startInstance(defaultName); err != nil {
+		err := a.lockedWorkspace(func() error { return a.startInstance(defaultName) })
+		if err != nil {
 			return err
 		}
 		a.instanceLog.Okf(a.t("log.workspace_started"))
@@ -119,7 +159,8 @@ func (a *App) newRootCommand() *cobra.Command {
 	}})
 
 	root.AddCommand(&cobra.Command{Use: "stop", Args: cobra.NoArgs, RunE: func(cmd *cobra.Command, args []string) error {
-		if err := a.stopInstance(defaultName); err != nil {
+		err := a.lockedWorkspace(func() error { return a.stopInstance(defaultName) })
+		if err != nil {
 			return err
 		}
 		a.instanceLog.Okf(a.t("log.workspace_stopped"))
@@ -127,7 +168,8 @@ func (a *App) newRootCommand() *cobra.Command {
 	}})
 
 	root.AddCommand(&cobra.Command{Use: "restart", Args: cobra.NoArgs, RunE: func(cmd *cobra.Command, args []string) error {
-		if err := a.restartInstance(defaultName); err != nil {
+		err := a.lockedWorkspace(func() error { return a.restartInstance(defaultName) })
+		if err != nil {
 			return err
 		}
 		a.instanceLog.Okf(a.t("log.workspace_restarted"))
@@ -135,18 +177,34 @@ func (a *App) newRootCommand() *cobra.Command {
 	}})
 
 	root.AddCommand(&cobra.Command{Use: "status", Args: cobra.NoArgs, RunE: func(cmd *cobra.Command, args []string) error {
-		return a.statusInstance(defaultName)
+		return a.statusInstance(cmd.Context(), defaultName)
 	}})
 
 	logs := &cobra.Command{Use: "logs", Args: cobra.NoArgs, RunE: func(cmd *cobra.Command, args []string) error {
-		tail, _ := cmd.Flags().GetInt("tail")
-		return a.logsInstance(defaultName, tail)
+		var opts logsOptions
+		opts.tail, _ = cmd.Flags().GetInt("tail")
+		opts.follow, _ = cmd.Flags().GetBool("follow")
+		opts.since, _ = cmd.Flags().GetString("since")
+		opts.until, _ = cmd.Flags().GetString("until")
+		opts.level, _ = cmd.Flags().GetString("level")
+		opts.module, _ = cmd.Flags().GetString("module")
+		opts.installer, _ = cmd.Flags().GetBool("installer")
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return a.logsInstance(ctx, defaultName, opts)
 	}}
 	logs.Flags().Int("tail", 50, "Tail lines")
+	logs.Flags().BoolP("follow", "f", false, "Keep streaming new lines, across log rotation")
+	logs.Flags().String("since", "", "Only lines at or after this time (\"2006-01-02 15:04:05\", \"15:04\", RFC 3339 or a duration such as 10m)")
+	logs.Flags().String("until", "", "Only lines at or before this time (same formats as --since)")
+	logs.Flags().String("level", "", "Only lines at this level or more severe (debug, info, warn, error)")
+	logs.Flags().String("module", "", "Only lines from these modules (comma separated, e.g. instance,maibot)")
+	logs.Flags().Bool("installer", false, "Read the global installer.log instead of the workspace log")
 	root.AddCommand(logs)
 
 	root.AddCommand(&cobra.Command{Use: "update", Args: cobra.NoArgs, RunE: func(cmd *cobra.Command, args []string) error {
-		if err := a.updateInstance(defaultName); err != nil {
+		err := a.lockedWorkspace(func() error { return a.updateInstance(cmd.Context(), defaultName) })
+		if err != nil {
 			return err
 		}
 		a.updateLog.Okf(a.t("log.workspace_updated"))
@@ -163,10 +221,10 @@ func (a *App) newRootCommand() *cobra.Command {
 
 	serviceCmd := &cobra.Command{Use: "service", Short: "Manage OS service for single workspace"}
 	serviceCmd.AddCommand(&cobra.Command{Use: "install", Args: cobra.NoArgs, RunE: func(cmd *cobra.Command, args []string) error {
-		return a.serviceAction("install", defaultName)
+		return a.lockedWorkspace(func() error { return a.serviceAction("install", defaultName) })
 	}})
 	serviceCmd.AddCommand(&cobra.Command{Use: "uninstall", Args: cobra.NoArgs, RunE: func(cmd *cobra.Command, args []string) error {
-		return a.serviceAction("uninstall", defaultName)
+		return a.lockedWorkspace(func() error { return a.serviceAction("uninstall", defaultName) })
 	}})
 	serviceCmd.AddCommand(&cobra.Command{Use: "start", Args: cobra.NoArgs, RunE: func(cmd *cobra.Command, args []string) error {
 		return a.serviceAction("start", defaultName)
@@ -180,19 +238,40 @@ func (a *App) newRootCommand() *cobra.Command {
 	root.AddCommand(serviceCmd)
 
 	cleanup := &cobra.Command{Use: "cleanup", Args: cobra.NoArgs, RunE: func(cmd *cobra.Command, args []string) error {
-		testArtifacts, _ := cmd.Flags().GetBool("test-artifacts")
-		if !testArtifacts {
-			return errors.New(a.t("err.cleanup_usage"))
-		}
-		if err := a.cleanup(); err != nil {
+		var opts cleanupOptions
+		opts.logs, _ = cmd.Flags().GetBool("logs")
+		opts.downloads, _ = cmd.Flags().GetBool("downloads")
+		opts.staleLocks, _ = cmd.Flags().GetBool("stale-locks")
+		opts.caches, _ = cmd.Flags().GetBool("caches")
+		opts.workspace, _ = cmd.Flags().GetBool("workspace")
+		opts.testArtifacts, _ = cmd.Flags().GetBool("test-artifacts")
+		opts.dryRun, _ = cmd.Flags().GetBool("dry-run")
+		opts.yes, _ = cmd.Flags().GetBool("yes")
+		if err := a.cleanup(cmd.Context(), opts); err != nil {
 			return err
 		}
-		a.cleanupLog.Okf(a.t("log.cleanup_completed"))
+		if !opts.dryRun {
+			a.cleanupLog.Okf(a.t("log.cleanup_completed"))
+		}
 		return nil
 	}}
-	cleanup.Flags().Bool("test-artifacts", false, "Clean local test artifacts")
+	cleanup.Flags().Bool("logs", false, "Delete rotated workspace and installer logs")
+	cleanup.Flags().Bool("downloads", false, "Delete module installer downloads such as NapCat.Shell.zip")
+	cleanup.Flags().Bool("stale-locks", false, "Delete locks that no process holds any more")
+	cleanup.Flags().Bool("caches", false, "Delete Python caches in MaiBot/ and leftover temp files")
+	cleanup.Flags().Bool("workspace", false, "Stop the worker and delete .maibot/ (workspace state and logs)")
+	cleanup.Flags().Bool("test-artifacts", false, "Same as --workspace --stale-locks")
+	cleanup.Flags().Bool("dry-run", false, "List what would be deleted with sizes, delete nothing")
+	cleanup.Flags().BoolP("yes", "y", false, "Do not ask for confirmation")
 	root.AddCommand(cleanup)
 
+	doctorCmd := &cobra.Command{Use: "doctor", Args: cobra.NoArgs, RunE: func(cmd *cobra.Command, args []string) error {
+		fix, _ := cmd.Flags().GetBool("fix")
+		return a.doctor(cmd.Context(), fix)
+	}}
+	doctorCmd.Flags().Bool("fix", false, "Repair safe issues: missing directories, dead locks, stale PIDs")
+	root.AddCommand(doctorCmd)
+
 	runCmd := &cobra.Command{Use: "run", Args: cobra.MinimumNArgs(1), RunE: func(cmd *cobra.Command, args []string) error {
 		sensitive, _ := cmd.Flags().GetBool("sensitive")
 		sudo, _ := cmd.Flags().GetBool("sudo")
@@ -205,23 +284,150 @@ func (a *App) newRootCommand() *cobra.Command {
 	root.AddCommand(runCmd)
 
 	root.AddCommand(&cobra.Command{Use: "version", Args: cobra.NoArgs, RunE: func(cmd *cobra.Command, args []string) error {
-		fmt.Println(version.InstallerVersion)
-		return nil
+		return a.render(map[string]string{"version": version.InstallerVersion}, func() {
+			fmt.Println(version.InstallerVersion)
+		})
 	}})
 
 	workspaceCmd := &cobra.Command{Use: "workspace", Short: "Workspace helpers"}
 	workspaceList := &cobra.Command{Use: "ls [paths...]", Aliases: []string{"list"}, Args: cobra.ArbitraryArgs, RunE: func(cmd *cobra.Command, args []string) error {
-		maxDepth, _ := cmd.Flags().GetInt("max-depth")
-		return a.listWorkspaces(args, maxDepth)
+		sel := workspaceSelection{paths: args}
+		sel.tag, _ = cmd.Flags().GetString("tag")
+		sel.scan, _ = cmd.Flags().GetBool("scan")
+		sel.maxDepth, _ = cmd.Flags().GetInt("max-depth")
+		return a.listWorkspaces(sel)
 	}}
-	workspaceList.Flags().Int("max-depth", 4, "Max recursive search depth")
+	workspaceList.Flags().String("tag", "", "Only list workspaces with this tag")
+	workspaceList.Flags().Bool("scan", false, "Scan paths for workspaces instead of reading the registry")
+	workspaceList.Flags().Int("max-depth", 4, "Max recursive search depth with --scan")
 	workspaceCmd.AddCommand(workspaceList)
+
+	workspaceExec := &cobra.Command{Use: "exec <start|stop|restart|status|update> [paths...]", Args: cobra.MinimumNArgs(1), RunE: func(cmd *cobra.Command, args []string) error {
+		sel := workspaceSelection{paths: args[1:]}
+		sel.tag, _ = cmd.Flags().GetString("tag")
+		all, _ := cmd.Flags().GetBool("all")
+		parallel, _ := cmd.Flags().GetInt("parallel")
+		return a.execWorkspaces(cmd.Context(), args[0], sel, all, parallel)
+	}}
+	workspaceExec.Flags().Bool("all", false, "Run in every registered workspace")
+	workspaceExec.Flags().String("tag", "", "Run in registered workspaces with this tag")
+	workspaceExec.Flags().Int("parallel", defaultExecParallel, "Number of workspaces handled at once")
+	workspaceCmd.AddCommand(workspaceExec)
+
+	workspaceTag := &cobra.Command{Use: "tag <tags...>", Args: cobra.MinimumNArgs(1), RunE: func(cmd *cobra.Command, args []string) error {
+		remove, _ := cmd.Flags().GetBool("remove")
+		if remove {
+			return a.tagWorkspace(nil, args)
+		}
+		return a.tagWorkspace(args, nil)
+	}}
+	workspaceTag.Flags().Bool("remove", false, "Remove the tags instead of adding them")
+	workspaceCmd.AddCommand(workspaceTag)
+
+	workspaceBackup := &cobra.Command{Use: "backup", Args: cobra.NoArgs, RunE: func(cmd *cobra.Command, args []string) error {
+		archive, _ := cmd.Flags().GetString("file")
+		return a.lockedWorkspace(func() error { return a.backupWorkspace(cmd.Context(), archive) })
+	}}
+	workspaceBackup.Flags().StringP("file", "f", "", "Archive path (default maibot-<dir>-<time>.tar.zst)")
+	workspaceCmd.AddCommand(workspaceBackup)
+
+	workspaceRestore := &cobra.Command{Use: "restore <archive> [directory]", Args: cobra.RangeArgs(1, 2), RunE: func(cmd *cobra.Command, args []string) error {
+		target := ""
+		if len(args) > 1 {
+			target = args[1]
+		}
+		noClone, _ := cmd.Flags().GetBool("no-clone")
+		return a.restoreWorkspace(cmd.Context(), args[0], target, noClone)
+	}}
+	workspaceRestore.Flags().Bool("no-clone", false, "Do not clone MaiBot; restore only the archived files")
+	workspaceCmd.AddCommand(workspaceRestore)
 	root.AddCommand(workspaceCmd)
 
+	configCmd := &cobra.Command{Use: "config", Short: "Inspect and change the maibot configuration"}
+	configShow := &cobra.Command{Use: "show", Args: cobra.NoArgs, RunE: func(cmd *cobra.Command, args []string) error {
+		origin, _ := cmd.Flags().GetBool("origin")
+		return a.showConfig(origin)
+	}}
+	configShow.Flags().Bool("origin", false, "Show the layer (default, global, workspace, env) each value came from")
+	configShow.Aliases = []string{"list", "ls"}
+	configCmd.AddCommand(configShow)
+	configCmd.AddCommand(&cobra.Command{Use: "get <key>", Args: cobra.ExactArgs(1), RunE: func(cmd *cobra.Command, args []string) error {
+		return a.getConfig(args[0])
+	}})
+	configSet := &cobra.Command{Use: "set <key> <values...>", Args: cobra.MinimumNArgs(2), RunE: func(cmd *cobra.Command, args []string) error {
+		workspace, _ := cmd.Flags().GetBool("workspace")
+		appendValues, _ := cmd.Flags().GetBool("append")
+		removeValues, _ := cmd.Flags().GetBool("remove")
+		mode := configSetReplace
+		switch {
+		case appendValues && removeValues:
+			return errors.New(a.t("err.config_set_mode"))
+		case appendValues:
+			mode = configSetAppend
+		case removeValues:
+			mode = configSetRemove
+		}
+		return a.setConfig(args[0], args[1:], mode, workspace)
+	}}
+	configSet.Flags().Bool("workspace", false, "Write the current workspace's .maibot/maibot.conf instead of the global file")
+	configSet.Flags().Bool("append", false, "Append the values to a list")
+	configSet.Flags().Bool("remove", false, "Remove the values from a list (objects also match by name)")
+	configCmd.AddCommand(configSet)
+	configUnset := &cobra.Command{Use: "unset <key>", Args: cobra.ExactArgs(1), RunE: func(cmd *cobra.Command, args []string) error {
+		workspace, _ := cmd.Flags().GetBool("workspace")
+		return a.unsetConfig(args[0], workspace)
+	}}
+	configUnset.Flags().Bool("workspace", false, "Drop the key from the workspace file instead of resetting the global one")
+	configCmd.AddCommand(configUnset)
+	configCmd.AddCommand(&cobra.Command{Use: "validate", Args: cobra.NoArgs, RunE: func(cmd *cobra.Command, args []string) error {
+		return a.validateConfigFiles()
+	}})
+	configEdit := &cobra.Command{Use: "edit", Args: cobra.NoArgs, RunE: func(cmd *cobra.Command, args []string) error {
+		workspace, _ := cmd.Flags().GetBool("workspace")
+		return a.editConfig(workspace)
+	}}
+	configEdit.Flags().Bool("workspace", false, "Edit the current workspace's .maibot/maibot.conf")
+	configCmd.AddCommand(configEdit)
+	configMigrate := &cobra.Command{Use: "migrate", Args: cobra.NoArgs, RunE: func(cmd *cobra.Command, args []string) error {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		if dryRun {
+			return a.previewConfigMigration()
+		}
+		return a.migrateConfig()
+	}}
+	configMigrate.Flags().Bool("dry-run", false, "Show the diff the migration would write without changing anything")
+	configCmd.AddCommand(configMigrate)
+	configCmd.AddCommand(&cobra.Command{Use: "backups", Args: cobra.NoArgs, RunE: func(cmd *cobra.Command, args []string) error {
+		return a.listConfigBackups()
+	}})
+	configCmd.AddCommand(&cobra.Command{Use: "restore <backup>", Args: cobra.ExactArgs(1), RunE: func(cmd *cobra.Command, args []string) error {
+		return a.restoreConfigBackup(args[0])
+	}})
+	root.AddCommand(configCmd)
+
+	locksCmd := &cobra.Command{Use: "locks", Short: "Inspect and break workspace locks"}
+	locksCmd.AddCommand(&cobra.Command{Use: "list", Aliases: []string{"ls"}, Args: cobra.NoArgs, RunE: func(cmd *cobra.Command, args []string) error {
+		return a.listLocks()
+	}})
+	locksBreak := &cobra.Command{Use: "break <name>", Args: cobra.ExactArgs(1), RunE: func(cmd *cobra.Command, args []string) error {
+		force, _ := cmd.Flags().GetBool("force")
+		return a.breakLock(args[0], force)
+	}}
+	locksBreak.Flags().Bool("force", false, "Break the lock even if its holder is still running")
+	locksCmd.AddCommand(locksBreak)
+	root.AddCommand(locksCmd)
+
 	modulesCmd := &cobra.Command{Use: "modules", Short: "Manage installable modules"}
 	modulesInstall := &cobra.Command{Use: "install <module>", Args: cobra.ExactArgs(1), RunE: func(cmd *cobra.Command, args []string) error {
 		mgr := modules.New(a.cfg.Modules, a.cfg.Mirrors, a.modulesLog, nil)
-		report, err := mgr.Install(cmd.Context(), args[0])
+		var report modules.InstallReport
+		err := a.lockedWorkspaceOrCwd(func() error {
+			var err error
+			if report, err = mgr.Install(cmd.Context(), args[0]); err != nil {
+				return err
+			}
+			return a.recordModule(report.Module, report.Source)
+		})
 		if err != nil {
 			return err
 		}
@@ -234,14 +440,19 @@ func (a *App) newRootCommand() *cobra.Command {
 		if err != nil {
 			return err
 		}
+		rows := make([]moduleRow, 0, len(defs))
 		for _, def := range defs {
-			desc := strings.TrimSpace(def.Description)
-			if desc == "" {
-				desc = a.t("modules.no_description")
-			}
-			fmt.Printf("%s\t%s\n", def.Name, desc)
+			rows = append(rows, moduleRow{Name: def.Name, Description: strings.TrimSpace(def.Description)})
 		}
-		return nil
+		return a.render(rows, func() {
+			for _, row := range rows {
+				desc := row.Description
+				if desc == "" {
+					desc = a.t("modules.no_description")
+				}
+				fmt.Printf("%s\t%s\n", row.Name, desc)
+			}
+		})
 	}}
 	modulesCmd.AddCommand(modulesInstall, modulesList)
 	root.AddCommand(modulesCmd)
@@ -255,13 +466,71 @@ func (a *App) newRootCommand() *cobra.Command {
 		if len(args) > 1 && strings.TrimSpace(args[1]) != "" {
 			displayName = strings.TrimSpace(args[1])
 		}
-		a.runInstance(id, displayName)
-		return nil
+		return a.runInstance(id, displayName)
 	}})
 
 	return root
 }
 
+// lifecycleCommands change what runs or what is installed; they refuse to
+// run on an invalid config. Everything else, config and doctor included,
+// keeps working so the config can be inspected and fixed.
+var lifecycleCommands = map[string]bool{
+	"maibot init":              true,
+	"maibot start":             true,
+	"maibot restart":           true,
+	"maibot update":            true,
+	"maibot upgrade":           true,
+	"maibot service install":   true,
+	"maibot service start":     true,
+	"maibot modules install":   true,
+	"maibot workspace restore": true,
+}
+
+// configHistoryCommands look at or replace the global file as it is on
+// disk, so a pending migration is not applied before them.
+var configHistoryCommands = map[string]bool{
+	"maibot config migrate": true,
+	"maibot config backups": true,
+	"maibot config restore": true,
+}
+
+func (a *App) chdir(path string) error {
+	if strings.TrimSpace(path) == "" {
+		return nil
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if st, err := os.Stat(abs); err != nil {
+		return err
+	} else if !st.IsDir() {
+		return errors.New(a.tf("err.chdir_not_directory", abs))
+	}
+	if err := os.Chdir(abs); err != nil {
+		return err
+	}
+	// The new directory may belong to a workspace with its own maibot.conf.
+	return a.loadConfig()
+}
+
+func (a *App) requireValidConfig() error {
+	problems := a.cfgLoaded.Problems
+	if len(problems) == 0 {
+		if err := a.validateConfig(); err != nil {
+			return errors.New(a.tf("err.invalid_config", err))
+		}
+		return nil
+	}
+	return errors.New(a.tf("err.config_refused", len(problems), problems.Error()))
+}
+
+type moduleRow struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description" yaml:"description"`
+}
+
 func (a *App) printHelp() {
 	fmt.Println(a.t("help.title"))
 	fmt.Println()
@@ -274,6 +543,9 @@ func (a *App) printHelp() {
 	fmt.Println(a.t("help.restart"))
 	fmt.Println(a.t("help.status"))
 	fmt.Println(a.t("help.workspace_ls"))
+	fmt.Println(a.t("help.workspace_exec"))
+	fmt.Println(a.t("help.workspace_tag"))
+	fmt.Println(a.t("help.workspace_backup"))
 	fmt.Println(a.t("help.logs"))
 	fmt.Println(a.t("help.update"))
 	fmt.Println(a.t("help.upgrade"))
@@ -281,7 +553,13 @@ func (a *App) printHelp() {
 	fmt.Println(a.t("help.modules_list"))
 	fmt.Println(a.t("help.service"))
 	fmt.Println(a.t("help.run"))
+	fmt.Println(a.t("help.locks"))
 	fmt.Println(a.t("help.cleanup"))
+	fmt.Println(a.t("help.doctor"))
+	fmt.Println(a.t("help.config_show"))
+	fmt.Println(a.t("help.config_set"))
+	fmt.Println(a.t("help.config_validate"))
+	fmt.Println(a.t("help.config_migrate"))
 	fmt.Println(a.t("help.version"))
 	fmt.Println(a.t("help.chdir"))
 }
@@ -298,20 +576,5 @@ func (a *App) tf(key string, args ...any) string {
 }
 
 func (a *App) validateConfig() error {
-	if strings.TrimSpace(a.cfg.Installer.Repo) == "" {
-		return errors.New("installer.repo is empty in config")
-	}
-	if strings.TrimSpace(a.cfg.Installer.ReleaseChannel) == "" {
-		return errors.New("installer.release_channel is empty in config")
-	}
-	if strings.TrimSpace(a.cfg.Installer.DataHome) == "" {
-		return errors.New("installer.data_home is empty in config")
-	}
-	if strings.TrimSpace(a.cfg.Installer.InstanceTickInterval) == "" {
-		return errors.New("installer.instance_tick_interval is empty in config")
-	}
-	if a.cfg.Updater.RequireSignature && strings.TrimSpace(a.cfg.Updater.MiniSignPublicKey) == "" {
-		return errors.New("updater.minisign_public_key is empty while signature is required")
-	}
-	return nil
+	return config.Validate(a.cfg)
 }
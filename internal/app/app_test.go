@@ -1,9 +1,17 @@
 package app
 
 import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"maibot/internal/config"
+	"maibot/internal/instance"
+	"maibot/internal/process"
+	"maibot/internal/registry"
 )
 
 func TestDefaultWorkspaceName(t *testing.T) {
@@ -18,3 +26,308 @@ func TestValidateConfig(t *testing.T) {
 		t.Fatalf("validateConfig error: %v", err)
 	}
 }
+
+func TestReconcileWorkspace(t *testing.T) {
+	const deadPID = 1 << 30
+	reusedStart := process.Lookup(os.Getpid()).StartTime + 1
+	cases := []struct {
+		in   workspaceConfig
+		want string
+	}{
+		{workspaceConfig{Status: workspaceStateRunning, PID: deadPID}, workspaceStateStopped},
+		{workspaceConfig{Status: workspaceStateStopped, PID: os.Getpid()}, workspaceStateRunning},
+		{workspaceConfig{Status: workspaceStateUpdating, OwnerPID: deadPID}, workspaceStateFailed},
+		{workspaceConfig{Status: workspaceStateUpdating, OwnerPID: os.Getpid()}, workspaceStateUpdating},
+		{workspaceConfig{Status: workspaceStateInstalled}, workspaceStateInstalled},
+		// The pid is alive but belongs to a process started at another time.
+		{workspaceConfig{Status: workspaceStateRunning, PID: os.Getpid(), PIDStartTime: reusedStart}, workspaceStateStopped},
+	}
+	for _, tc := range cases {
+		got := reconcileWorkspace(tc.in)
+		if got.Status != tc.want {
+			t.Fatalf("reconcileWorkspace(%s pid=%d owner=%d) = %s, want %s", tc.in.Status, tc.in.PID, tc.in.OwnerPID, got.Status, tc.want)
+		}
+		if tc.in.PID == deadPID && got.PID != 0 {
+			t.Fatalf("dead pid was kept: %d", got.PID)
+		}
+	}
+}
+
+func TestLogFilter(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.Local)
+	a := &App{}
+	f, err := a.newLogFilter(logsOptions{since: "11:00", level: "warn", module: "maibot"}, now)
+	if err != nil {
+		t.Fatalf("newLogFilter error: %v", err)
+	}
+	lines := []struct {
+		line string
+		want bool
+	}{
+		{"2025-03-01 10:59:59 | ERROR | maibot | too early", false},
+		{"2025-03-01 11:30:00 | INFO | maibot | below level", false},
+		{"2025-03-01 11:30:01 | ERROR | instance | other module", false},
+		{"2025-03-01 11:30:02 | ERROR | maibot | Traceback (most recent call last):", true},
+		{`  File "bot.py", line 1`, true},
+		{"2025-03-01 11:30:03 | INFO | maibot | recovered", false},
+		{"  unrelated continuation", false},
+	}
+	for _, tc := range lines {
+		if got := f.keep(tc.line, now); got != tc.want {
+			t.Fatalf("keep(%q) = %v, want %v", tc.line, got, tc.want)
+		}
+	}
+
+	if _, err := a.newLogFilter(logsOptions{until: "yesterday"}, now); err == nil {
+		t.Fatalf("expected invalid --until to be rejected")
+	}
+}
+
+func TestSetOutput(t *testing.T) {
+	a := &App{}
+	for raw, want := range map[string]string{"": outputTable, "JSON": outputJSON, "yaml": outputYAML} {
+		if err := a.setOutput(raw); err != nil || a.output != want {
+			t.Fatalf("setOutput(%q) = %q, %v; want %q", raw, a.output, err, want)
+		}
+	}
+	if err := a.setOutput("xml"); err == nil {
+		t.Fatalf("expected unknown output format to be rejected")
+	}
+}
+
+func TestFilterWorkspacesUnder(t *testing.T) {
+	base := t.TempDir()
+	entries := []registry.Entry{
+		{Path: filepath.Join(base, "bots", "a")},
+		{Path: filepath.Join(base, "bots-old", "b")},
+		{Path: filepath.Join(base, "other", "c")},
+	}
+	missing := filepath.Join(base, "missing")
+	got, unmatched, err := filterWorkspacesUnder(entries, []string{filepath.Join(base, "bots"), missing})
+	if err != nil {
+		t.Fatalf("filterWorkspacesUnder error: %v", err)
+	}
+	if len(got) != 1 || got[0].Path != entries[0].Path {
+		t.Fatalf("unexpected entries: %+v", got)
+	}
+	if len(unmatched) != 1 || unmatched[0] != missing {
+		t.Fatalf("a path without workspaces should be reported, got %v", unmatched)
+	}
+	if all, _, _ := filterWorkspacesUnder(entries, nil); len(all) != len(entries) {
+		t.Fatalf("no paths should keep every entry, got %+v", all)
+	}
+}
+
+func TestSafeWorkspacePath(t *testing.T) {
+	root := t.TempDir()
+	if got, ok := safeWorkspacePath(root, "modules/napcat/NapCat.Shell.zip"); !ok || got != filepath.Join(root, "modules", "napcat", "NapCat.Shell.zip") {
+		t.Fatalf("safeWorkspacePath = %q, %v", got, ok)
+	}
+	for _, rel := range []string{"", ".", "../outside", "modules/../../outside", "/etc/passwd", "~/.config"} {
+		if got, ok := safeWorkspacePath(root, rel); ok {
+			t.Fatalf("safeWorkspacePath(%q) = %q, want rejected", rel, got)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	cases := map[int64]string{0: "0 B", 1023: "1023 B", 1024: "1.0 KiB", 5 << 20: "5.0 MiB"}
+	for in, want := range cases {
+		if got := formatBytes(in); got != want {
+			t.Fatalf("formatBytes(%d) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestEnvFilePort(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("# comment\nHOST=127.0.0.1\nexport PORT=\"8001\"\n"), 0o644); err != nil {
+		t.Fatalf("write .env: %v", err)
+	}
+	if port, ok := envFilePort(path); !ok || port != 8001 {
+		t.Fatalf("envFilePort = %d, %v; want 8001", port, ok)
+	}
+	if err := os.WriteFile(path, []byte("PORT=http\n"), 0o644); err != nil {
+		t.Fatalf("write .env: %v", err)
+	}
+	if _, ok := envFilePort(path); ok {
+		t.Fatalf("expected invalid PORT to be ignored")
+	}
+}
+
+func TestRestoredConfigIsNotMigratedBack(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("MAIBOT_HOME", filepath.Join(home, ".maibot"))
+	t.Setenv("MAIBOT_LANG", "en")
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getwd: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	if err := os.Chdir(home); err != nil {
+		t.Fatalf("chdir: %v", err)
+	}
+	path := filepath.Join(home, ".maibot", "maibot.conf")
+	original := "{\n  \"version\": 2,\n  \"installer\": {\n    \"repo\": \"x/y\"\n  }\n}\n"
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(original), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	run := func(args ...string) {
+		t.Helper()
+		a, err := New()
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		if err := a.Execute(append([]string{"-o", "json"}, args...)); err != nil {
+			t.Fatalf("maibot %v: %v", args, err)
+		}
+	}
+	// Any command migrates the file and keeps the original as a backup.
+	run("config", "get", "version")
+	backups, err := config.ListBackups()
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("backups after migration = %+v, %v", backups, err)
+	}
+
+	run("config", "restore", backups[0].Name)
+	run("config", "get", "version")
+	if data, _ := os.ReadFile(path); string(data) != original {
+		t.Fatalf("restored config was migrated again:\n%s", data)
+	}
+
+	run("config", "migrate")
+	run("config", "get", "version")
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), `"version": 3`) {
+		t.Fatalf("explicit migration did not apply:\n%s", data)
+	}
+}
+
+func TestCleanupLogsKeepsOnlyTheLiveFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), ".maibot")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	a := &App{cfg: config.Config{Logging: config.Logging{FilePath: filepath.Join(dir, "missing", "installer.log")}}}
+	opts := cleanupOptions{logs: true}
+
+	// Neither the live log nor any rotated segment exists.
+	items, err := a.collectCleanup(context.Background(), dir, opts)
+	if err != nil || len(items) != 0 {
+		t.Fatalf("collectCleanup with no logs = %+v, %v", items, err)
+	}
+
+	// Right after a rotation only rotated segments exist; all of them go.
+	rotated := []string{"workspace-2025-03-01T10-00-00.000.log", "workspace-2025-03-02T10-00-00.000.log"}
+	for _, name := range rotated {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x\n"), 0o644); err != nil {
+			t.Fatalf("write segment: %v", err)
+		}
+	}
+	items, err = a.collectCleanup(context.Background(), dir, opts)
+	if err != nil || len(items) != len(rotated) {
+		t.Fatalf("collectCleanup with rotated-only logs = %+v, %v", items, err)
+	}
+	for i, item := range items {
+		if filepath.Base(item.Path) != rotated[i] {
+			t.Fatalf("item %d = %s, want %s", i, item.Path, rotated[i])
+		}
+	}
+
+	// The live log itself is never listed.
+	live := filepath.Join(dir, workspaceLogName)
+	if err := os.WriteFile(live, []byte("x\n"), 0o644); err != nil {
+		t.Fatalf("write live log: %v", err)
+	}
+	items, err = a.collectCleanup(context.Background(), dir, opts)
+	if err != nil || len(items) != len(rotated) {
+		t.Fatalf("collectCleanup with live log = %+v, %v", items, err)
+	}
+	for _, item := range items {
+		if item.Path == live {
+			t.Fatalf("live log was listed for cleanup")
+		}
+	}
+}
+
+// lockedTestWorkspace creates a workspace with a live log, makes it the
+// current directory and holds its workspace lock like another maibot would.
+// It returns the App for running commands and the path of the held lock.
+func lockedTestWorkspace(t *testing.T) (func() *App, string) {
+	t.Helper()
+	home, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("eval symlinks: %v", err)
+	}
+	t.Setenv("HOME", home)
+	t.Setenv("MAIBOT_HOME", filepath.Join(home, ".maibot"))
+	t.Setenv("MAIBOT_LANG", "en")
+	t.Setenv("MAIBOT_INSTALLER__LOCK_TIMEOUT_SECONDS", "1")
+	dir := filepath.Join(home, "bot", ".maibot")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte("{\"name\":\"bot\"}\n"), 0o644); err != nil {
+		t.Fatalf("write workspace config: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, workspaceLogName), []byte("hello\n"), 0o644); err != nil {
+		t.Fatalf("write workspace log: %v", err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getwd: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	if err := os.Chdir(filepath.Dir(dir)); err != nil {
+		t.Fatalf("chdir: %v", err)
+	}
+
+	newApp := func() *App {
+		t.Helper()
+		a, err := New()
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		return a
+	}
+	lockDir, err := newApp().lockDir()
+	if err != nil {
+		t.Fatalf("lockDir: %v", err)
+	}
+	name := workspaceServiceName(dir)
+	lock, err := instance.AcquireLock(lockDir, name, time.Second)
+	if err != nil {
+		t.Fatalf("AcquireLock: %v", err)
+	}
+	t.Cleanup(func() { _ = lock.Release() })
+	return newApp, filepath.Join(lockDir, name+".lock")
+}
+
+func TestWritersFailWhileWorkspaceIsLocked(t *testing.T) {
+	newApp, lockPath := lockedTestWorkspace(t)
+	for _, args := range [][]string{{"start"}, {"update"}, {"modules", "install", "napcat"}} {
+		a := newApp()
+		want := a.tf("err.workspace_locked_by", os.Getpid(), lockPath)
+		err := a.Execute(args)
+		if err == nil || err.Error() != want {
+			t.Fatalf("maibot %v = %v, want %q", args, err, want)
+		}
+	}
+}
+
+func TestReadersIgnoreWorkspaceLock(t *testing.T) {
+	newApp, _ := lockedTestWorkspace(t)
+	for _, args := range [][]string{{"status"}, {"logs"}, {"config", "get", "version"}} {
+		started := time.Now()
+		if err := newApp().Execute(append([]string{"-o", "json"}, args...)); err != nil {
+			t.Fatalf("maibot %v: %v", args, err)
+		}
+		if waited := time.Since(started); waited >= time.Second {
+			t.Fatalf("maibot %v waited %s for the workspace lock", args, waited)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	kservice "github.com/kardianos/service"
+
+	"maibot/internal/backup"
+	"maibot/internal/gitops"
+	"maibot/internal/modules"
+	"maibot/internal/process"
+	"maibot/internal/version"
+)
+
+const backupExt = ".tar.zst"
+
+// backupSkipDirs are never archived below MaiBot/: git objects and the
+// virtualenv are recreated by restore, caches are worthless elsewhere.
+var backupSkipDirs = map[string]bool{
+	".git":         true,
+	".venv":        true,
+	"venv":         true,
+	"__pycache__":  true,
+	"node_modules": true,
+}
+
+// backupWorkspace archives the current workspace: .maibot/, MaiBot/ without
+// git objects and virtualenvs, and the state paths of installed modules.
+func (a *App) backupWorkspace(ctx context.Context, output string) error {
+	dir, err := a.workspaceDir(defaultName)
+	if err != nil {
+		return err
+	}
+	root := filepath.Dir(dir)
+	cfg, err := readWorkspaceConfigByPath(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	if strings.TrimSpace(output) == "" {
+		output = fmt.Sprintf("maibot-%s-%s%s", sanitizeServiceToken(filepath.Base(root)), now.Format("20060102-150405"), backupExt)
+	}
+	archive, err := filepath.Abs(output)
+	if err != nil {
+		return err
+	}
+
+	manifest := backup.Manifest{
+		CreatedAt:        now,
+		InstallerVersion: version.InstallerVersion,
+		Workspace:        backup.ManifestWorkspace{Name: cfg.Name, Path: root},
+		MaiBot:           backup.ManifestMaiBot{Repo: cfg.Repo, Ref: cfg.Ref},
+	}
+	if mb := a.maibotStatus(ctx, dir); mb != nil {
+		manifest.MaiBot.Commit = mb.Commit
+	}
+	if reg, err := a.registry(); err == nil {
+		if entries, err := reg.List(); err == nil {
+			for _, e := range entries {
+				if e.Path == root {
+					manifest.Workspace.Tags = e.Tags
+				}
+			}
+		}
+	}
+	if svc, name, err := workspaceService(dir); err == nil {
+		_, statusErr := svc.Status()
+		manifest.Service = backup.ManifestService{Name: name, Installed: statusErr == nil}
+	}
+
+	paths := []string{".maibot", "MaiBot"}
+	for _, m := range cfg.Modules {
+		manifest.Modules = append(manifest.Modules, m.Name)
+	}
+	paths = append(paths, a.moduleStatePaths(ctx, manifest.Modules)...)
+
+	home, _ := os.UserHomeDir()
+	src := backup.Source{
+		Root:  root,
+		Home:  home,
+		Paths: paths,
+		Skip: func(rel string, isDir bool) bool {
+			if filepath.Join(root, filepath.FromSlash(rel)) == archive {
+				return true
+			}
+			if strings.HasPrefix(rel, ".maibot/") {
+				return !isDir && (strings.HasSuffix(rel, ".tmp") || strings.HasSuffix(rel, ".lock"))
+			}
+			if strings.HasPrefix(rel, "MaiBot/") {
+				return isDir && backupSkipDirs[filepath.Base(rel)]
+			}
+			return false
+		},
+	}
+
+	a.instanceLog.Infof(a.tf("log.backup_writing", archive))
+	partial := archive + ".partial"
+	f, err := os.OpenFile(partial, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	stats, err := backup.Write(f, manifest, src)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(partial, archive)
+	}
+	if err != nil {
+		_ = os.Remove(partial)
+		return errors.New(a.tf("err.backup_failed", err))
+	}
+
+	a.instanceLog.Okf(a.tf("log.backup_written", archive, stats.Files, stats.Bytes))
+	return nil
+}
+
+// moduleStatePaths returns the state paths declared by the named modules.
+// Modules missing from every catalog contribute nothing.
+func (a *App) moduleStatePaths(ctx context.Context, names []string) []string {
+	if len(names) == 0 {
+		return nil
+	}
+	defs, err := modules.New(a.cfg.Modules, a.cfg.Mirrors, a.modulesLog, nil).List(ctx)
+	if err != nil {
+		return nil
+	}
+	wanted := map[string]bool{}
+	for _, name := range names {
+		wanted[name] = true
+	}
+	var paths []string
+	for _, def := range defs {
+		if !wanted[def.Name] {
+			continue
+		}
+		for _, p := range def.State {
+			if p = strings.TrimSpace(p); p != "" {
+				paths = append(paths, p)
+			}
+		}
+	}
+	return paths
+}
+
+// restoreWorkspace rebuilds the archived workspace in target: MaiBot is cloned
+// and reset to the archived commit, the archive is unpacked over it, and the
+// workspace is registered and its service re-created for the new path.
+func (a *App) restoreWorkspace(ctx context.Context, archive, target string, noClone bool) error {
+	archive, err := filepath.Abs(archive)
+	if err != nil {
+		return err
+	}
+	manifest, err := backup.ReadManifest(archive)
+	if err != nil {
+		return errors.New(a.tf("err.restore_read_failed", archive, err))
+	}
+	if strings.TrimSpace(target) == "" {
+		target = "."
+	}
+	root, err := filepath.Abs(target)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(root, ".maibot")
+	if _, err := os.Stat(filepath.Join(dir, "config.json")); err == nil {
+		return errors.New(a.tf("err.restore_target_exists", root))
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	return a.withWorkspaceLock(dir, func() error {
+		repoDir := maibotDir(dir)
+		if !noClone && strings.TrimSpace(manifest.MaiBot.Repo) != "" {
+			if err := a.cloneMaiBot(ctx, dir, manifest.MaiBot.Repo, manifest.MaiBot.Ref); err != nil {
+				return err
+			}
+			if commit := manifest.MaiBot.Commit; commit != "" {
+				if err := gitops.New(a.cfg.Git, a.gitLog).Reset(ctx, repoDir, commit); err != nil {
+					a.gitLog.Warnf(a.tf("log.restore_reset_failed", commit, err))
+				}
+			}
+		}
+
+		a.instanceLog.Infof(a.tf("log.restore_extracting", archive, root))
+		// Home entries are only restored where the archived modules declare
+		// their state.
+		home, _ := os.UserHomeDir()
+		stats, err := backup.Extract(archive, backup.Target{
+			Root:  root,
+			Home:  home,
+			Paths: a.moduleStatePaths(ctx, manifest.Modules),
+		})
+		if err != nil {
+			return errors.New(a.tf("err.restore_failed", err))
+		}
+
+		// The archived runtime state belongs to the old host.
+		configPath := filepath.Join(dir, "config.json")
+		cfg, err := readWorkspaceConfigByPath(configPath)
+		if err != nil {
+			return errors.New(a.tf("err.restore_failed", err))
+		}
+		cfg.Status = workspaceStateInstalled
+		cfg.setWorker(process.Identity{})
+		cfg.OwnerPID = 0
+		cfg.StartedAt = nil
+		cfg.UpdatedAt = time.Now().UTC()
+		if err := writeWorkspaceConfig(configPath, cfg); err != nil {
+			return err
+		}
+		a.registerWorkspace(root, cfg.Name, manifest.Workspace.Tags)
+
+		if !noClone {
+			if _, err := os.Stat(filepath.Join(repoDir, "pyproject.toml")); err == nil {
+				if err := a.syncDependencies(ctx, repoDir); err != nil {
+					a.updateLog.Warnf(a.tf("log.restore_sync_failed", err))
+				}
+			}
+		}
+
+		serviceName := workspaceServiceName(dir)
+		if manifest.Service.Installed {
+			if err := a.reinstallService(dir); err != nil {
+				a.instanceLog.Warnf(a.tf("log.restore_service_failed", serviceName, err))
+			} else {
+				a.instanceLog.Infof(a.tf("log.restore_service_installed", serviceName))
+			}
+		}
+		a.instanceLog.Okf(a.tf("log.restore_completed", root, stats.Files, stats.Skipped))
+		return nil
+	})
+}
+
+// reinstallService installs the service for the workspace at dir, replacing
+// a stale definition left by an earlier restore to the same path.
+func (a *App) reinstallService(dir string) error {
+	svc, _, err := workspaceService(dir)
+	if err != nil {
+		return err
+	}
+	if _, err := svc.Status(); !errors.Is(err, kservice.ErrNotInstalled) {
+		_ = svc.Uninstall()
+	}
+	return svc.Install()
+}
@@ -1,31 +1,260 @@
 package app
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
 
-	"maibot/internal/process"
+	"maibot/internal/execx"
+	"maibot/internal/instance"
+	"maibot/internal/logging"
+	"maibot/internal/modules"
 )
 
-func (a *App) cleanup() error {
+const (
+	cleanupScopeLogs       = "logs"
+	cleanupScopeDownloads  = "downloads"
+	cleanupScopeStaleLocks = "stale-locks"
+	cleanupScopeCaches     = "caches"
+	cleanupScopeWorkspace  = "workspace"
+)
+
+// cacheDirNames are directories below MaiBot/ that tools recreate on demand.
+var cacheDirNames = map[string]bool{
+	"__pycache__":   true,
+	".pytest_cache": true,
+	".mypy_cache":   true,
+	".ruff_cache":   true,
+}
+
+type cleanupOptions struct {
+	logs          bool
+	downloads     bool
+	staleLocks    bool
+	caches        bool
+	workspace     bool
+	testArtifacts bool
+	dryRun        bool
+	yes           bool
+}
 
-	if err := cleanupRepoArtifacts(); err != nil {
+// needsWorkspace reports whether a selected scope lives inside the workspace.
+func (o cleanupOptions) needsWorkspace() bool {
+	return o.logs || o.downloads || o.caches || o.workspace
+}
+
+func (o cleanupOptions) empty() bool {
+	return !o.needsWorkspace() && !o.staleLocks && !o.testArtifacts
+}
+
+type cleanupItem struct {
+	Scope string `json:"scope" yaml:"scope"`
+	Path  string `json:"path" yaml:"path"`
+	Bytes int64  `json:"bytes" yaml:"bytes"`
+	// Lock is the lock name for stale-locks items.
+	Lock string `json:"lock,omitempty" yaml:"lock,omitempty"`
+}
+
+func (a *App) cleanup(ctx context.Context, opts cleanupOptions) error {
+	if opts.testArtifacts {
+		// The historical flag: the workspace state plus leftover locks, run
+		// unattended by test scripts, so it does not prompt.
+		opts.workspace, opts.staleLocks, opts.yes = true, true, true
+	}
+	if opts.empty() {
+		return errors.New(a.t("err.cleanup_usage"))
+	}
+	if !opts.needsWorkspace() {
+		return a.runCleanup(ctx, "", opts)
+	}
+	dir, err := a.workspaceDir(defaultName)
+	if err != nil {
 		return err
 	}
+	return a.withWorkspaceLock(dir, func() error { return a.runCleanup(ctx, dir, opts) })
+}
 
-	if err := a.removeWorkspace(); err != nil {
+func (a *App) runCleanup(ctx context.Context, dir string, opts cleanupOptions) error {
+	items, err := a.collectCleanup(ctx, dir, opts)
+	if err != nil {
 		return err
 	}
+	var total int64
+	for _, item := range items {
+		total += item.Bytes
+	}
 
-	if err := a.cleanupLocks(); err != nil {
-		return err
+	if opts.dryRun {
+		return a.render(items, func() {
+			if len(items) == 0 {
+				fmt.Println(a.t("cleanup.nothing"))
+				return
+			}
+			for _, item := range items {
+				fmt.Printf("%s\t%s\t%s\n", item.Scope, formatBytes(item.Bytes), item.Path)
+			}
+			fmt.Println(a.tf("cleanup.total", len(items), formatBytes(total)))
+		})
+	}
+
+	if len(items) > 0 && !opts.yes {
+		ok, err := execx.NewRunner().Confirm(a.tf("cleanup.confirm", len(items), formatBytes(total)))
+		if err != nil {
+			return errors.New(a.tf("err.cleanup_confirm", err))
+		}
+		if !ok {
+			return errors.New(a.t("err.cleanup_cancelled"))
+		}
+	}
+
+	if opts.testArtifacts {
+		if err := cleanupRepoArtifacts(); err != nil {
+			return err
+		}
+	}
+	for _, item := range items {
+		if err := a.removeCleanupItem(dir, item); err != nil {
+			return err
+		}
+		a.cleanupLog.Infof(a.tf("log.cleanup_removed", item.Scope, item.Path, formatBytes(item.Bytes)))
 	}
 	return nil
 }
 
+// collectCleanup lists what the selected scopes would delete. Nothing is
+// removed here, so the same list backs --dry-run and the real run.
+func (a *App) collectCleanup(ctx context.Context, dir string, opts cleanupOptions) ([]cleanupItem, error) {
+	var items []cleanupItem
+	add := func(scope, path string) {
+		size, err := pathSize(path)
+		if err != nil {
+			return
+		}
+		items = append(items, cleanupItem{Scope: scope, Path: path, Bytes: size})
+	}
+	root := filepath.Dir(dir)
+
+	// The workspace scope removes all of .maibot/, which covers the logs and
+	// caches kept there.
+	if opts.workspace {
+		add(cleanupScopeWorkspace, dir)
+	}
+	if opts.logs {
+		logPaths := []string{a.cfg.Logging.FilePath}
+		if !opts.workspace {
+			logPaths = append(logPaths, filepath.Join(dir, workspaceLogName))
+		}
+		for _, logPath := range logPaths {
+			if strings.TrimSpace(logPath) == "" {
+				continue
+			}
+			segments, err := logging.Segments(logPath)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			// Only rotated segments go. The live file is only listed when it
+			// exists, so it cannot be told apart by position.
+			for _, segment := range segments {
+				if segment != logPath {
+					add(cleanupScopeLogs, segment)
+				}
+			}
+		}
+	}
+	if opts.downloads {
+		defs, err := modules.New(a.cfg.Modules, a.cfg.Mirrors, a.modulesLog, nil).List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		seen := map[string]bool{}
+		for _, def := range defs {
+			for _, rel := range def.Downloads {
+				path, ok := safeWorkspacePath(root, rel)
+				if !ok || seen[path] {
+					continue
+				}
+				seen[path] = true
+				add(cleanupScopeDownloads, path)
+			}
+		}
+	}
+	if opts.caches {
+		repoDir := maibotDir(dir)
+		if _, err := os.Stat(repoDir); err == nil {
+			err := filepath.WalkDir(repoDir, func(path string, d fs.DirEntry, err error) error {
+				if err != nil || !d.IsDir() {
+					return nil
+				}
+				switch {
+				case d.Name() == ".git" || d.Name() == ".venv" || d.Name() == "venv":
+					return filepath.SkipDir
+				case cacheDirNames[d.Name()]:
+					add(cleanupScopeCaches, path)
+					return filepath.SkipDir
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !opts.workspace {
+			// Leftovers of interrupted atomic writes and backups.
+			for _, pattern := range []string{"*.tmp", "*.partial"} {
+				matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+				for _, match := range matches {
+					add(cleanupScopeCaches, match)
+				}
+			}
+		}
+	}
+	if opts.staleLocks {
+		lockDir, err := a.lockDir()
+		if err != nil {
+			return nil, err
+		}
+		infos, err := instance.ListLocks(lockDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			if !info.Stale() {
+				continue
+			}
+			items = append(items, cleanupItem{Scope: cleanupScopeStaleLocks, Path: info.Path, Lock: info.Name})
+		}
+	}
+	return items, nil
+}
+
+func (a *App) removeCleanupItem(dir string, item cleanupItem) error {
+	switch item.Scope {
+	case cleanupScopeStaleLocks:
+		lockDir, err := a.lockDir()
+		if err != nil {
+			return err
+		}
+		// BreakLock takes the lock over before removing it, so a lock taken
+		// since the listing is left alone.
+		if _, err := instance.BreakLock(lockDir, item.Lock, false); err != nil && !errors.Is(err, os.ErrNotExist) {
+			if errors.Is(err, instance.ErrLockHeld) {
+				a.cleanupLog.Warnf(a.tf("log.cleanup_lock_in_use", item.Lock))
+				return nil
+			}
+			return err
+		}
+		return nil
+	case cleanupScopeWorkspace:
+		return a.removeWorkspace(dir)
+	default:
+		return removePathIfExists(item.Path)
+	}
+}
+
 func cleanupRepoArtifacts() error {
 	if strings.TrimSpace(os.Getenv("MAIBOT_ALLOW_DEV_CLEANUP")) != "1" {
 		return nil
@@ -43,43 +272,61 @@ func cleanupRepoArtifacts() error {
 	return nil
 }
 
-func (a *App) removeWorkspace() error {
-	dir, err := a.workspaceDir(defaultName)
-	if err != nil {
-		return err
-	}
-	cfg, err := a.readWorkspaceConfig(defaultName)
+func (a *App) removeWorkspace(dir string) error {
+	cfg, err := readWorkspaceConfigByPath(filepath.Join(dir, "config.json"))
 	if err == nil && cfg.PID > 0 {
-		if process.IsAlive(cfg.PID) {
-			_ = process.Stop(cfg.PID, 5*time.Second)
-		}
+		_ = cfg.worker().Stop(workerStopGrace)
 	}
 	if err := removePathIfExists(dir); err != nil {
 		return err
 	}
-	a.cleanupLog.Infof(a.t("log.cleanup_workspace_artifacts_completed"))
+	a.deregisterWorkspace(filepath.Dir(dir))
 	return nil
 }
 
-func (a *App) cleanupLocks() error {
-	root, err := a.dataRoot()
-	if err != nil {
-		return err
+// safeWorkspacePath joins a workspace-relative path from a module catalog to
+// root, refusing anything that would leave the workspace.
+func safeWorkspacePath(root, rel string) (string, bool) {
+	rel = strings.TrimSpace(rel)
+	if rel == "" || filepath.IsAbs(rel) || strings.HasPrefix(rel, "~") {
+		return "", false
 	}
-	lockDir := filepath.Join(root, "locks")
-	entries, err := os.ReadDir(lockDir)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return nil
-		}
-		return err
+	path := filepath.Join(root, filepath.FromSlash(rel))
+	r, err := filepath.Rel(root, path)
+	if err != nil || r == "." || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+		return "", false
 	}
-	for _, entry := range entries {
-		if err := removePathIfExists(filepath.Join(lockDir, entry.Name())); err != nil {
+	return path, true
+}
+
+// pathSize returns the size of a file or the total size of a directory tree.
+func pathSize(path string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
 			return err
 		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total, err
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
 	}
-	return nil
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
 }
 
 func removePathIfExists(path string) error {
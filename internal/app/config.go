@@ -0,0 +1,354 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"maibot/internal/config"
+	"maibot/internal/execx"
+)
+
+type configRow struct {
+	Key    string `json:"key" yaml:"key"`
+	Value  any    `json:"value" yaml:"value"`
+	Layer  string `json:"layer,omitempty" yaml:"layer,omitempty"`
+	Source string `json:"source,omitempty" yaml:"source,omitempty"`
+}
+
+// showConfig prints every effective config value by its dotted path and,
+// with origin, the layer and file or variable it came from.
+func (a *App) showConfig(origin bool) error {
+	values, err := a.cfgLoaded.Values()
+	if err != nil {
+		return err
+	}
+	keys := a.cfgLoaded.Keys()
+	rows := make([]configRow, 0, len(keys))
+	for _, key := range keys {
+		row := configRow{Key: key, Value: values[key]}
+		if origin {
+			o := a.cfgLoaded.Origins[key]
+			row.Layer, row.Source = o.Layer, o.Source
+		}
+		rows = append(rows, row)
+	}
+	return a.render(rows, func() {
+		if origin {
+			fmt.Println(a.tf("config.layer_global", a.cfgLoaded.GlobalPath))
+			workspace := a.cfgLoaded.WorkspacePath
+			if workspace == "" {
+				workspace = "-"
+			}
+			fmt.Println(a.tf("config.layer_workspace", workspace))
+			fmt.Println(a.tf("config.layer_env", strings.Join(a.envOverrides(), ", ")))
+			fmt.Println()
+		}
+		for _, row := range rows {
+			if !origin {
+				fmt.Printf("%s = %s\n", row.Key, formatConfigValue(row.Value))
+				continue
+			}
+			from := row.Layer
+			if row.Source != "" {
+				from += " " + row.Source
+			}
+			fmt.Printf("%s = %s\t(%s)\n", row.Key, formatConfigValue(row.Value), from)
+		}
+	})
+}
+
+// envOverrides lists the MAIBOT_ variables that set effective values, or "-".
+func (a *App) envOverrides() []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, o := range a.cfgLoaded.Origins {
+		if o.Layer != config.LayerEnv {
+			continue
+		}
+		for _, name := range strings.Split(o.Source, ", ") {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return []string{"-"}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// formatConfigValue prints strings bare and everything else as JSON, the way
+// it would be written in maibot.conf.
+func formatConfigValue(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// getConfig prints the effective value at key, or every value below it when
+// key names a section such as "git".
+func (a *App) getConfig(key string) error {
+	values, err := a.cfgLoaded.Values()
+	if err != nil {
+		return err
+	}
+	if v, ok := values[key]; ok {
+		return a.render(configRow{Key: key, Value: v}, func() {
+			fmt.Println(formatConfigValue(v))
+		})
+	}
+	var rows []configRow
+	for _, k := range a.cfgLoaded.Keys() {
+		if strings.HasPrefix(k, key+".") {
+			rows = append(rows, configRow{Key: k, Value: values[k]})
+		}
+	}
+	if len(rows) == 0 {
+		return errors.New(a.tf("err.config_unknown_key", key))
+	}
+	return a.render(rows, func() {
+		for _, row := range rows {
+			fmt.Printf("%s = %s\n", row.Key, formatConfigValue(row.Value))
+		}
+	})
+}
+
+// openConfig opens the global maibot.conf or, with workspace, the one of the
+// current workspace.
+func (a *App) openConfig(workspace bool) (*config.Document, error) {
+	if !workspace {
+		return config.OpenGlobal()
+	}
+	dir, err := a.workspaceDir(defaultName)
+	if err != nil {
+		return nil, err
+	}
+	return config.OpenWorkspace(dir)
+}
+
+const (
+	configSetReplace = "replace"
+	configSetAppend  = "append"
+	configSetRemove  = "remove"
+)
+
+func (a *App) setConfig(key string, values []string, mode string, workspace bool) error {
+	doc, err := a.openConfig(workspace)
+	if err != nil {
+		return err
+	}
+	switch mode {
+	case configSetAppend:
+		err = doc.Append(key, values)
+	case configSetRemove:
+		err = doc.Remove(key, values)
+	default:
+		err = doc.Set(key, values)
+	}
+	if err == nil {
+		err = doc.Save()
+	}
+	if err != nil {
+		return errors.New(a.tf("err.config_set_failed", key, err))
+	}
+	a.log.Okf(a.tf("log.config_set", key, doc.Path))
+	return nil
+}
+
+func (a *App) unsetConfig(key string, workspace bool) error {
+	doc, err := a.openConfig(workspace)
+	if err != nil {
+		return err
+	}
+	if err := doc.Unset(key); err != nil {
+		return errors.New(a.tf("err.config_set_failed", key, err))
+	}
+	if err := doc.Save(); err != nil {
+		return errors.New(a.tf("err.config_set_failed", key, err))
+	}
+	a.log.Okf(a.tf("log.config_unset", key, doc.Path))
+	return nil
+}
+
+// editConfig opens a copy of the file in $VISUAL or $EDITOR and saves it once
+// it validates. An invalid edit can be reopened or discarded; the file is
+// never left half edited.
+func (a *App) editConfig(workspace bool) error {
+	doc, err := a.openConfig(workspace)
+	if err != nil {
+		return err
+	}
+	original, err := os.ReadFile(doc.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		original = []byte("{\n}\n")
+	} else if err != nil {
+		return err
+	}
+	editor := configEditor()
+
+	tmp, err := os.CreateTemp("", "maibot-*"+filepath.Ext(doc.Path))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(original); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	for {
+		cmd := exec.Command(editor[0], append(editor[1:], tmp.Name())...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return errors.New(a.tf("err.config_editor_failed", strings.Join(editor, " "), err))
+		}
+		edited, err := os.ReadFile(tmp.Name())
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(edited, original) {
+			a.log.Infof(a.tf("log.config_unchanged", doc.Path))
+			return nil
+		}
+		if err = doc.Replace(edited); err == nil {
+			err = doc.Save()
+		}
+		if err == nil {
+			a.log.Okf(a.tf("log.config_saved", doc.Path))
+			return nil
+		}
+		a.log.Errorf(a.tf("log.config_edit_invalid", err))
+		again, confirmErr := execx.NewRunner().Confirm(a.t("config.edit_again"))
+		if confirmErr != nil || !again {
+			return errors.New(a.tf("err.config_edit_discarded", doc.Path))
+		}
+	}
+}
+
+// configEditor returns the editor command from $VISUAL or $EDITOR, which may
+// carry arguments such as "code --wait".
+func configEditor() []string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(name)); len(fields) > 0 {
+			return fields
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return []string{"notepad"}
+	}
+	return []string{"vi"}
+}
+
+// validateConfigFiles reports every problem in the config layers that apply
+// here: the global file, the workspace file and MAIBOT_ variables.
+func (a *App) validateConfigFiles() error {
+	problems := a.cfgLoaded.Problems
+	if problems == nil {
+		problems = config.Problems{}
+	}
+	err := a.render(problems, func() {
+		if len(problems) == 0 {
+			fmt.Println(a.t("config.valid"))
+			return
+		}
+		for _, p := range problems {
+			fmt.Println(p.String())
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return errors.New(a.tf("err.config_invalid", len(problems)))
+	}
+	return nil
+}
+
+// migrateConfig brings the global config to the current schema version and
+// reloads it. Before other commands it runs on its own; its log lines are
+// kept out of json and yaml output.
+func (a *App) migrateConfig() error {
+	m, err := config.Migrate()
+	if err != nil {
+		return errors.New(a.tf("err.config_migrate_failed", err))
+	}
+	if a.output == outputTable {
+		if m.Backup == "" {
+			a.log.Infof(a.tf("log.config_current", m.To))
+		} else {
+			a.log.Okf(a.tf("log.config_migrated", m.From, m.To, m.Target, m.Backup))
+		}
+	}
+	return a.loadConfig()
+}
+
+// previewConfigMigration prints the diff a migration would write.
+func (a *App) previewConfigMigration() error {
+	preview, err := config.PreviewMigration()
+	if err != nil {
+		return errors.New(a.tf("err.config_migrate_failed", err))
+	}
+	return a.render(preview, func() {
+		if preview.Diff == "" {
+			fmt.Println(a.tf("config.migrate_current", preview.To))
+			return
+		}
+		fmt.Println(a.tf("config.migrate_plan", preview.From, preview.To))
+		fmt.Print(preview.Diff)
+	})
+}
+
+func (a *App) listConfigBackups() error {
+	backups, err := config.ListBackups()
+	if err != nil {
+		return err
+	}
+	return a.render(backups, func() {
+		if len(backups) == 0 {
+			fmt.Println(a.t("config.backups_none"))
+			return
+		}
+		for _, b := range backups {
+			fmt.Printf("%s\tv%d\t%d B\t%s\n", b.Name, b.Version, b.Bytes, b.CreatedAt.Local().Format(time.RFC3339))
+		}
+	})
+}
+
+// restoreConfigBackup makes a backup the global config again. The file it
+// replaces becomes a backup itself.
+func (a *App) restoreConfigBackup(name string) error {
+	restored, saved, err := config.RestoreBackup(name)
+	if err != nil {
+		return errors.New(a.tf("err.config_restore_failed", name, err))
+	}
+	if saved != "" {
+		a.log.Infof(a.tf("log.config_backed_up", saved))
+	}
+	a.log.Okf(a.tf("log.config_restored", restored.Name))
+	if err := a.loadConfig(); err != nil {
+		return err
+	}
+	if m := a.cfgLoaded.Migration; m != nil && m.Pinned {
+		a.log.Infof(a.tf("log.config_pinned", m.From))
+	}
+	return nil
+}
@@ -0,0 +1,444 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	kservice "github.com/kardianos/service"
+
+	"maibot/internal/execx"
+	"maibot/internal/fetchx"
+	"maibot/internal/instance"
+	"maibot/internal/modules"
+)
+
+const (
+	doctorPass = "pass"
+	doctorWarn = "warn"
+	doctorFail = "fail"
+
+	// doctorMinFreeBytes is the free space below which installs and updates
+	// (git objects, a Python virtualenv, NapCat) are likely to fail.
+	doctorMinFreeBytes = 1 << 30
+	doctorToolTimeout  = 10 * time.Second
+)
+
+type doctorCheck struct {
+	Name   string `json:"name" yaml:"name"`
+	Status string `json:"status" yaml:"status"`
+	Detail string `json:"detail" yaml:"detail"`
+	Hint   string `json:"hint,omitempty" yaml:"hint,omitempty"`
+	Fixed  bool   `json:"fixed,omitempty" yaml:"fixed,omitempty"`
+}
+
+// doctor runs every check and, with fix, repairs what is safe to repair:
+// missing directories, dead locks and stale worker PIDs. It fails when any
+// check still fails afterwards.
+func (a *App) doctor(ctx context.Context, fix bool) error {
+	var checks []doctorCheck
+	report := func(name, status, detail, hint string) *doctorCheck {
+		checks = append(checks, doctorCheck{Name: name, Status: status, Detail: detail, Hint: hint})
+		return &checks[len(checks)-1]
+	}
+
+	dir, inWorkspace, err := detectWorkspaceDir()
+	if err != nil {
+		return err
+	}
+
+	a.doctorTools(ctx, report, dir, inWorkspace)
+	a.doctorConfig(report)
+	a.doctorDirectories(report, dir, inWorkspace, fix)
+	a.doctorLocks(report, fix)
+	if inWorkspace {
+		a.doctorWorkspaceState(report, dir, fix)
+	}
+	a.doctorMirrors(ctx, report)
+	a.doctorPorts(ctx, report, dir, inWorkspace)
+	a.doctorSudo(ctx, report)
+
+	failed := 0
+	for _, c := range checks {
+		if c.Status == doctorFail {
+			failed++
+		}
+	}
+	err = a.render(checks, func() {
+		for _, c := range checks {
+			mark := strings.ToUpper(c.Status)
+			if c.Fixed {
+				mark = "FIXED"
+			}
+			fmt.Printf("[%s] %s: %s\n", mark, c.Name, c.Detail)
+			if c.Hint != "" && c.Status != doctorPass {
+				fmt.Printf("       %s\n", c.Hint)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return errors.New(a.tf("err.doctor_failed", failed))
+	}
+	return nil
+}
+
+type doctorReport func(name, status, detail, hint string) *doctorCheck
+
+func (a *App) doctorTools(ctx context.Context, report doctorReport, dir string, inWorkspace bool) {
+	if v, err := toolVersion(ctx, "git", "--version"); err != nil {
+		report("git", doctorFail, a.tf("doctor.tool_missing", "git"), a.t("doctor.hint_git"))
+	} else {
+		report("git", doctorPass, v, "")
+	}
+
+	// uv is only required once MaiBot is a uv project.
+	uvStatus := doctorWarn
+	if inWorkspace {
+		if _, err := os.Stat(filepath.Join(maibotDir(dir), "pyproject.toml")); err == nil {
+			uvStatus = doctorFail
+		}
+	}
+	if v, err := toolVersion(ctx, "uv", "--version"); err != nil {
+		report("uv", uvStatus, a.tf("doctor.tool_missing", "uv"), a.t("doctor.hint_uv"))
+	} else {
+		report("uv", doctorPass, v, "")
+	}
+
+	var python string
+	var pyErr error
+	for _, name := range []string{"python3", "python"} {
+		if python, pyErr = toolVersion(ctx, name, "--version"); pyErr == nil {
+			break
+		}
+	}
+	if pyErr != nil {
+		report("python", doctorWarn, a.tf("doctor.tool_missing", "python3"), a.t("doctor.hint_python"))
+	} else {
+		report("python", doctorPass, python, "")
+	}
+}
+
+func toolVersion(ctx context.Context, name string, args ...string) (string, error) {
+	if _, err := exec.LookPath(name); err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, doctorToolTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return "", err
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	return strings.TrimSpace(line), nil
+}
+
+// doctorConfig reports what the config validator found in every layer.
+func (a *App) doctorConfig(report doctorReport) {
+	problems := a.cfgLoaded.Problems
+	if len(problems) == 0 {
+		if err := a.validateConfig(); err != nil {
+			report("config", doctorFail, err.Error(), a.t("doctor.hint_config"))
+			return
+		}
+		report("config", doctorPass, a.t("doctor.config_ok"), "")
+		return
+	}
+	details := make([]string, len(problems))
+	for i, p := range problems {
+		details[i] = p.String()
+	}
+	report("config", doctorFail, strings.Join(details, "; "), a.t("doctor.hint_config"))
+}
+
+// doctorDirectories checks that the directories maibot writes to exist, are
+// writable and have room.
+func (a *App) doctorDirectories(report doctorReport, dir string, inWorkspace bool, fix bool) {
+	dirs := []string{a.cfg.Installer.DataHome}
+	if lockDir, err := a.lockDir(); err == nil {
+		dirs = append(dirs, lockDir)
+	}
+	if p := strings.TrimSpace(a.cfg.Logging.FilePath); p != "" {
+		dirs = append(dirs, filepath.Dir(p))
+	}
+	if inWorkspace {
+		dirs = append(dirs, dir, filepath.Join(filepath.Dir(dir), "modules"))
+	}
+
+	for _, d := range dirs {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		name := "dir " + d
+		if _, err := os.Stat(d); errors.Is(err, os.ErrNotExist) {
+			if !fix {
+				report(name, doctorWarn, a.t("doctor.dir_missing"), a.t("doctor.hint_fix"))
+				continue
+			}
+			if err := os.MkdirAll(d, 0o755); err != nil {
+				report(name, doctorFail, err.Error(), a.t("doctor.hint_permissions"))
+				continue
+			}
+			report(name, doctorPass, a.t("doctor.dir_created"), "").Fixed = true
+			continue
+		}
+		if err := checkWritable(d); err != nil {
+			report(name, doctorFail, a.tf("doctor.dir_not_writable", err), a.t("doctor.hint_permissions"))
+			continue
+		}
+		report(name, doctorPass, a.t("doctor.dir_writable"), "")
+	}
+
+	spaceDir := a.cfg.Installer.DataHome
+	if inWorkspace {
+		spaceDir = filepath.Dir(dir)
+	}
+	free, err := diskFree(spaceDir)
+	switch {
+	case err != nil:
+		report("disk", doctorWarn, err.Error(), "")
+	case free < doctorMinFreeBytes:
+		report("disk", doctorWarn, a.tf("doctor.disk_low", formatBytes(int64(free)), spaceDir), a.t("doctor.hint_disk"))
+	default:
+		report("disk", doctorPass, a.tf("doctor.disk_free", formatBytes(int64(free)), spaceDir), "")
+	}
+}
+
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".maibot-doctor-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	_ = f.Close()
+	return os.Remove(name)
+}
+
+func (a *App) doctorLocks(report doctorReport, fix bool) {
+	lockDir, err := a.lockDir()
+	if err != nil {
+		return
+	}
+	infos, err := instance.ListLocks(lockDir)
+	if err != nil {
+		report("locks", doctorWarn, err.Error(), "")
+		return
+	}
+	var stale []string
+	for _, info := range infos {
+		if info.Stale() {
+			stale = append(stale, info.Name)
+		}
+	}
+	if len(stale) == 0 {
+		report("locks", doctorPass, a.tf("doctor.locks_ok", len(infos)), "")
+		return
+	}
+	if !fix {
+		report("locks", doctorWarn, a.tf("doctor.locks_stale", strings.Join(stale, ", ")), a.t("doctor.hint_fix"))
+		return
+	}
+	for _, name := range stale {
+		// Take the lock over rather than deleting the file: acquiring it
+		// fails if someone holds it again by now, and releasing it removes
+		// the file the way its holder would have.
+		lock, err := instance.AcquireLock(lockDir, name, 0)
+		if err == nil {
+			err = lock.Release()
+		}
+		if err != nil {
+			report("locks", doctorWarn, err.Error(), "")
+			return
+		}
+	}
+	report("locks", doctorPass, a.tf("doctor.locks_cleared", strings.Join(stale, ", ")), "").Fixed = true
+}
+
+// doctorWorkspaceState compares the recorded worker with the process table
+// and the service manager.
+func (a *App) doctorWorkspaceState(report doctorReport, dir string, fix bool) {
+	configPath := filepath.Join(dir, "config.json")
+	cfg, err := readWorkspaceConfigByPath(configPath)
+	if err != nil {
+		report("workspace", doctorFail, err.Error(), a.t("doctor.hint_reinit"))
+		return
+	}
+	reconciled := reconcileWorkspace(cfg)
+	switch {
+	case reconciled.Status == cfg.Status && reconciled.PID == cfg.PID:
+		report("workspace", doctorPass, a.tf("doctor.workspace_consistent", cfg.Status, cfg.PID), "")
+	case !fix:
+		report("workspace", doctorWarn, a.tf("doctor.workspace_stale", cfg.Status, cfg.PID, reconciled.Status), a.t("doctor.hint_fix"))
+	default:
+		err := a.withWorkspaceLock(dir, func() error {
+			// Reconcile again: a command may have changed the state since.
+			return updateWorkspaceConfig(configPath, func(latest *workspaceConfig) {
+				reconciled = reconcileWorkspace(*latest)
+				*latest = reconciled
+			})
+		})
+		if err != nil {
+			report("workspace", doctorFail, err.Error(), a.t("doctor.hint_permissions"))
+		} else {
+			report("workspace", doctorPass, a.tf("doctor.workspace_reset", cfg.PID, reconciled.Status), "").Fixed = true
+		}
+	}
+
+	svc, name, err := workspaceService(dir)
+	if err != nil {
+		return
+	}
+	status, err := svc.Status()
+	switch {
+	case errors.Is(err, kservice.ErrNotInstalled):
+		report("service", doctorPass, a.tf("doctor.service_not_installed", name), "")
+	case err != nil:
+		report("service", doctorWarn, a.tf("doctor.service_unknown", name, err), "")
+	case status == kservice.StatusRunning && reconciled.Status != workspaceStateRunning:
+		report("service", doctorWarn, a.tf("doctor.service_mismatch", name, reconciled.Status), a.t("doctor.hint_service"))
+	default:
+		report("service", doctorPass, a.tf("doctor.service_status", name, serviceStatusName(status)), "")
+	}
+}
+
+func (a *App) doctorMirrors(ctx context.Context, report doctorReport) {
+	resolver := fetchx.NewResolver(a.cfg.Mirrors.URLs, a.cfg.Mirrors.ProbeURL, a.cfg.Mirrors.ProbeSeconds, nil)
+	mirrors := resolver.Mirrors()
+	var down []string
+	for _, m := range mirrors {
+		if !resolver.Probe(ctx, m) {
+			down = append(down, m)
+		}
+	}
+	switch {
+	case len(down) == 0:
+		report("mirrors", doctorPass, a.tf("doctor.mirrors_ok", len(mirrors)), "")
+	case len(down) == len(mirrors):
+		report("mirrors", doctorWarn, a.tf("doctor.mirrors_down", strings.Join(down, ", ")), a.t("doctor.hint_mirrors"))
+	default:
+		report("mirrors", doctorWarn, a.tf("doctor.mirrors_some_down", len(down), len(mirrors), strings.Join(down, ", ")), a.t("doctor.hint_mirrors"))
+	}
+}
+
+// doctorPorts checks that the ports MaiBot (PORT in MaiBot/.env) and the
+// module catalog declare are free. A running workspace is expected to hold
+// them, so they are only probed while it is stopped.
+func (a *App) doctorPorts(ctx context.Context, report doctorReport, dir string, inWorkspace bool) {
+	ports := map[int]string{}
+	if inWorkspace {
+		if port, ok := envFilePort(filepath.Join(maibotDir(dir), ".env")); ok {
+			ports[port] = "MaiBot"
+		}
+	}
+	if defs, err := modules.New(a.cfg.Modules, a.cfg.Mirrors, nil, nil).List(ctx); err == nil {
+		for _, def := range defs {
+			for _, port := range def.Ports {
+				if _, ok := ports[port]; !ok {
+					ports[port] = def.Name
+				}
+			}
+		}
+	}
+	if inWorkspace {
+		if cfg, err := readWorkspaceConfigByPath(filepath.Join(dir, "config.json")); err == nil && reconcileWorkspace(cfg).Status == workspaceStateRunning {
+			report("ports", doctorPass, a.t("doctor.ports_running"), "")
+			return
+		}
+	}
+	numbers := make([]int, 0, len(ports))
+	for port := range ports {
+		numbers = append(numbers, port)
+	}
+	sort.Ints(numbers)
+	var busy []string
+	for _, port := range numbers {
+		owner := ports[port]
+		ln, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(port)))
+		if err != nil {
+			busy = append(busy, fmt.Sprintf("%d (%s)", port, owner))
+			continue
+		}
+		_ = ln.Close()
+	}
+	if len(busy) > 0 {
+		report("ports", doctorWarn, a.tf("doctor.ports_busy", strings.Join(busy, ", ")), a.t("doctor.hint_ports"))
+		return
+	}
+	report("ports", doctorPass, a.tf("doctor.ports_ok", len(ports)), "")
+}
+
+// envFilePort reads PORT from a dotenv file.
+func envFilePort(path string) (int, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer func() { _ = f.Close() }()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok || strings.TrimSpace(strings.TrimPrefix(key, "export ")) != "PORT" {
+			continue
+		}
+		port, err := strconv.Atoi(strings.Trim(strings.TrimSpace(value), `"'`))
+		if err != nil || port <= 0 || port > 65535 {
+			return 0, false
+		}
+		return port, true
+	}
+	return 0, false
+}
+
+// doctorSudo warns when module steps need sudo or a confirmation prompt that
+// this session cannot provide.
+func (a *App) doctorSudo(ctx context.Context, report doctorReport) {
+	defs, err := modules.New(a.cfg.Modules, a.cfg.Mirrors, nil, nil).List(ctx)
+	if err != nil {
+		return
+	}
+	var needSudo, needTTY []string
+	for _, def := range defs {
+		sudo, tty := false, false
+		for _, step := range def.Install {
+			sudo = sudo || step.RequireSudo
+			tty = tty || step.Sensitive
+		}
+		if sudo {
+			needSudo = append(needSudo, def.Name)
+		}
+		if sudo || tty {
+			needTTY = append(needTTY, def.Name)
+		}
+	}
+	if len(needTTY) == 0 {
+		return
+	}
+	runner := execx.NewRunner()
+	root := runner.IsRoot()
+	switch {
+	case len(needSudo) > 0 && !root && runtime.GOOS != "windows" && !hasCommand("sudo"):
+		report("sudo", doctorWarn, a.tf("doctor.sudo_missing", strings.Join(needSudo, ", ")), a.t("doctor.hint_sudo"))
+	case !runner.IsTTY():
+		report("sudo", doctorWarn, a.tf("doctor.tty_missing", strings.Join(needTTY, ", ")), a.t("doctor.hint_tty"))
+	default:
+		report("sudo", doctorPass, a.t("doctor.sudo_ok"), "")
+	}
+}
+
+func hasCommand(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
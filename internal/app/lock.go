@@ -0,0 +1,147 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"maibot/internal/instance"
+)
+
+// withWorkspaceLock runs fn while holding the per-workspace lock for the
+// workspace whose .maibot directory is dir. Mutating commands take it so
+// concurrent CLI, TUI and service invocations cannot interleave writes.
+func (a *App) withWorkspaceLock(dir string, fn func() error) error {
+	lockDir, err := a.lockDir()
+	if err != nil {
+		return err
+	}
+	timeout := time.Duration(a.cfg.Installer.LockTimeoutSeconds) * time.Second
+	lock, err := instance.AcquireLock(lockDir, workspaceServiceName(dir), timeout)
+	if err != nil {
+		var timeoutErr *instance.LockTimeoutError
+		if errors.As(err, &timeoutErr) {
+			if timeoutErr.PID > 0 {
+				return errors.New(a.tf("err.workspace_locked_by", timeoutErr.PID, timeoutErr.Path))
+			}
+			return errors.New(a.tf("err.workspace_locked", timeoutErr.Path))
+		}
+		return err
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			a.log.Warnf(a.tf("log.workspace_lock_release_failed", err))
+		}
+	}()
+	return fn()
+}
+
+// lockedWorkspace is withWorkspaceLock for the workspace containing the
+// current directory.
+func (a *App) lockedWorkspace(fn func() error) error {
+	dir, err := a.workspaceDir(defaultName)
+	if err != nil {
+		return err
+	}
+	return a.withWorkspaceLock(dir, fn)
+}
+
+// lockedWorkspaceOrCwd locks the enclosing workspace, or the one that would be
+// initialized in the current directory when there is none yet.
+func (a *App) lockedWorkspaceOrCwd(fn func() error) error {
+	dir, found, err := detectWorkspaceDir()
+	if err != nil {
+		return err
+	}
+	if !found {
+		if dir, err = workspaceDirForInit(); err != nil {
+			return err
+		}
+	}
+	return a.withWorkspaceLock(dir, fn)
+}
+
+// lockedInitWorkspace locks the workspace init would create in the current
+// directory.
+func (a *App) lockedInitWorkspace(fn func() error) error {
+	dir, err := workspaceDirForInit()
+	if err != nil {
+		return err
+	}
+	return a.withWorkspaceLock(dir, fn)
+}
+
+func (a *App) lockDir() (string, error) {
+	root, err := a.dataRoot()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, "locks"), nil
+}
+
+func (a *App) listLocks() error {
+	dir, err := a.lockDir()
+	if err != nil {
+		return err
+	}
+	infos, err := instance.ListLocks(dir)
+	if err != nil {
+		return err
+	}
+	rows := make([]lockRow, 0, len(infos))
+	for _, info := range infos {
+		rows = append(rows, lockRow{
+			Name:      info.Name,
+			Path:      info.Path,
+			PID:       info.PID,
+			Held:      info.Held,
+			Alive:     info.Alive,
+			Stale:     info.Stale(),
+			CreatedAt: info.CreatedAt,
+		})
+	}
+	return a.render(rows, func() {
+		if len(rows) == 0 {
+			fmt.Println(a.t("locks.none"))
+			return
+		}
+		for _, row := range rows {
+			state := "held"
+			if row.Stale {
+				state = "stale"
+			}
+			fmt.Printf("%s\tpid=%d\t%s\t%s\n", row.Name, row.PID, state, row.CreatedAt.Format(time.RFC3339))
+		}
+	})
+}
+
+type lockRow struct {
+	Name      string    `json:"name" yaml:"name"`
+	Path      string    `json:"path" yaml:"path"`
+	PID       int       `json:"pid" yaml:"pid"`
+	Held      bool      `json:"held" yaml:"held"`
+	Alive     bool      `json:"alive" yaml:"alive"`
+	Stale     bool      `json:"stale" yaml:"stale"`
+	CreatedAt time.Time `json:"created_at" yaml:"created_at"`
+}
+
+func (a *App) breakLock(name string, force bool) error {
+	dir, err := a.lockDir()
+	if err != nil {
+		return err
+	}
+	info, err := instance.BreakLock(dir, name, force)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return errors.New(a.tf("err.lock_not_found", name))
+		}
+		if errors.Is(err, instance.ErrLockHeld) {
+			return errors.New(a.tf("err.lock_held", info.Name, info.PID))
+		}
+		return err
+	}
+	a.log.Okf(a.tf("log.lock_broken", info.Name, info.PID))
+	return nil
+}
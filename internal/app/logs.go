@@ -0,0 +1,276 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"maibot/internal/logging"
+)
+
+const logFollowInterval = 250 * time.Millisecond
+
+type logsOptions struct {
+	tail      int
+	follow    bool
+	since     string
+	until     string
+	level     string
+	module    string
+	installer bool
+}
+
+// logFilter selects log entries. Lines that do not parse as an entry are
+// continuation lines (stack traces, multi-line child output) and follow the
+// decision made for the entry before them.
+type logFilter struct {
+	since    time.Time
+	until    time.Time
+	minLevel int
+	modules  map[string]bool
+	keepNext bool
+}
+
+func (a *App) newLogFilter(opts logsOptions, now time.Time) (*logFilter, error) {
+	f := &logFilter{minLevel: -1, keepNext: true}
+	var err error
+	if f.since, err = parseLogTime(opts.since, now); err != nil {
+		return nil, errors.New(a.tf("err.logs_invalid_time", "--since", opts.since))
+	}
+	if f.until, err = parseLogTime(opts.until, now); err != nil {
+		return nil, errors.New(a.tf("err.logs_invalid_time", "--until", opts.until))
+	}
+	if level := strings.TrimSpace(opts.level); level != "" {
+		if f.minLevel = logging.LevelRank(level); f.minLevel < 0 {
+			return nil, errors.New(a.tf("err.logs_invalid_level", level))
+		}
+	}
+	for _, module := range strings.Split(opts.module, ",") {
+		if module = strings.TrimSpace(module); module != "" {
+			if f.modules == nil {
+				f.modules = map[string]bool{}
+			}
+			f.modules[module] = true
+		}
+	}
+	return f, nil
+}
+
+func (f *logFilter) keep(line string, now time.Time) bool {
+	entry, ok := logging.ParseLine(line, now)
+	if !ok {
+		return f.keepNext
+	}
+	f.keepNext = f.match(entry)
+	return f.keepNext
+}
+
+func (f *logFilter) match(entry logging.Entry) bool {
+	if !f.since.IsZero() && entry.Time.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && entry.Time.After(f.until) {
+		return false
+	}
+	if f.minLevel >= 0 && logging.LevelRank(entry.Level) < f.minLevel {
+		return false
+	}
+	if f.modules != nil && !f.modules[entry.Module] {
+		return false
+	}
+	return true
+}
+
+// parseLogTime accepts an absolute time in the log or RFC 3339 formats, a
+// clock time for today, or a duration meaning that long ago.
+func parseLogTime(raw string, now time.Time) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(raw); err == nil {
+		return now.Add(-d), nil
+	}
+	if ts, err := time.Parse(time.RFC3339, raw); err == nil {
+		return ts, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if ts, err := time.ParseInLocation(layout, raw, now.Location()); err == nil {
+			return ts, nil
+		}
+	}
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if ts, err := time.ParseInLocation(layout, raw, now.Location()); err == nil {
+			return time.Date(now.Year(), now.Month(), now.Day(), ts.Hour(), ts.Minute(), ts.Second(), 0, now.Location()), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", raw)
+}
+
+func (a *App) logsInstance(ctx context.Context, name string, opts logsOptions) error {
+	logPath := a.cfg.Logging.FilePath
+	if !opts.installer {
+		var err error
+		if logPath, err = a.workspaceLogPath(name); err != nil {
+			return err
+		}
+	}
+	filter, err := a.newLogFilter(opts, time.Now())
+	if err != nil {
+		return err
+	}
+	if opts.tail <= 0 {
+		opts.tail = 50
+	}
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return errors.New(a.t("err.workspace_log_not_found"))
+		}
+		return err
+	}
+	lines, offset, err := tailLogLines(f, filter, opts.tail)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	following := filter.keepNext
+
+	// Older lines live in rotated segments; walk them newest first until
+	// enough lines matched.
+	segments, err := logging.Segments(logPath)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	for i := len(segments) - 2; i >= 0 && len(lines) < opts.tail; i-- {
+		if st, err := os.Stat(segments[i]); err == nil && st.ModTime().Before(filter.since) {
+			break
+		}
+		older, err := tailLogFile(segments[i], filter, opts.tail-len(lines))
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+		lines = append(older, lines...)
+	}
+	for _, line := range lines {
+		_, _ = io.WriteString(os.Stdout, line+"\n")
+	}
+	if !opts.follow {
+		return f.Close()
+	}
+	filter.keepNext = following
+	return followLog(ctx, logPath, f, offset, filter)
+}
+
+// tailLogLines returns the last n lines of r that pass filter, and how many
+// bytes were read. Only n lines are held in memory.
+func tailLogLines(r io.Reader, filter *logFilter, n int) ([]string, int64, error) {
+	ring := make([]string, n)
+	matched := 0
+	filter.keepNext = true
+	offset, err := readLogLines(bufio.NewReader(r), func(line string) {
+		if filter.keep(line, time.Now()) {
+			ring[matched%n] = line
+			matched++
+		}
+	})
+	if err != nil {
+		return nil, offset, err
+	}
+	out := make([]string, 0, min(matched, n))
+	for i := max(0, matched-n); i < matched; i++ {
+		out = append(out, ring[i%n])
+	}
+	return out, offset, nil
+}
+
+func tailLogFile(path string, filter *logFilter, n int) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	lines, _, err := tailLogLines(f, filter, n)
+	return lines, err
+}
+
+// readLogLines calls fn for every complete line and returns how many bytes
+// were consumed. A trailing partial line is left for the next read.
+func readLogLines(r *bufio.Reader, fn func(string)) (int64, error) {
+	var consumed int64
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return consumed, nil
+			}
+			return consumed, err
+		}
+		consumed += int64(len(line))
+		fn(strings.TrimRight(line, "\r\n"))
+	}
+}
+
+// followLog streams lines appended to path until ctx is done and closes f.
+// When the file is rotated (replaced or truncated) the rest of the old file is
+// drained and reading restarts at the beginning of the new one.
+func followLog(ctx context.Context, path string, f *os.File, offset int64, filter *logFilter) error {
+	defer func() { _ = f.Close() }()
+	emit := func(line string) {
+		if filter.keep(line, time.Now()) {
+			_, _ = io.WriteString(os.Stdout, line+"\n")
+		}
+	}
+	ticker := time.NewTicker(logFollowInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		n, err := readLogLines(bufio.NewReader(f), emit)
+		if err != nil {
+			return err
+		}
+		offset += n
+
+		current, statErr := os.Stat(path)
+		if statErr != nil {
+			// Between the rename and the new file being created.
+			continue
+		}
+		held, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if os.SameFile(held, current) {
+			if current.Size() < offset {
+				// Truncated in place.
+				offset = 0
+			}
+			continue
+		}
+		next, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		_ = f.Close()
+		f, offset = next, 0
+	}
+}
@@ -0,0 +1,93 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"maibot/internal/execx"
+	"maibot/internal/gitops"
+)
+
+type initOptions struct {
+	repo    string
+	ref     string
+	noClone bool
+	tags    []string
+}
+
+func maibotDir(workspaceDir string) string {
+	return filepath.Join(filepath.Dir(workspaceDir), "MaiBot")
+}
+
+// cloneMaiBot clones the MaiBot repository into the workspace unless it is
+// already a git checkout. The clone report is persisted whether or not the
+// clone succeeded.
+func (a *App) cloneMaiBot(ctx context.Context, workspaceDir string, repo string, ref string) error {
+	dest := maibotDir(workspaceDir)
+	if st, err := os.Stat(filepath.Join(dest, ".git")); err == nil && st.IsDir() {
+		a.gitLog.Infof(a.tf("log.maibot_already_cloned", dest))
+		return nil
+	}
+	entries, err := os.ReadDir(dest)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(entries) > 0 {
+		return errors.New(a.tf("err.maibot_dir_not_empty", dest))
+	}
+
+	a.gitLog.Infof(a.tf("log.maibot_cloning", repo, ref))
+	report, cloneErr := gitops.New(a.cfg.Git, a.gitLog).CloneRef(ctx, repo, ref, dest)
+	if err := writeGitReport(workspaceDir, report); err != nil {
+		a.gitLog.Warnf(a.tf("log.git_report_write_failed", err))
+	}
+	if cloneErr != nil {
+		return errors.New(a.tf("err.maibot_clone_failed", cloneErr))
+	}
+	a.gitLog.Okf(a.tf("log.maibot_cloned", report.UsedSource.Name, len(report.Attempts)))
+	return nil
+}
+
+// pullAndSync pulls the latest MaiBot commit and re-syncs its Python
+// dependencies. The pull report is persisted whether or not the pull succeeded.
+func (a *App) pullAndSync(ctx context.Context, mgr *gitops.Manager, workspaceDir string, repoDir string) error {
+	report, pullErr := mgr.Pull(ctx, repoDir)
+	if err := writeGitReport(workspaceDir, report); err != nil {
+		a.gitLog.Warnf(a.tf("log.git_report_write_failed", err))
+	}
+	if pullErr != nil {
+		return errors.New(a.tf("err.update_pull_failed", pullErr))
+	}
+	if err := a.syncDependencies(ctx, repoDir); err != nil {
+		return errors.New(a.tf("err.update_sync_failed", err))
+	}
+	return nil
+}
+
+// syncDependencies runs uv sync for uv-managed checkouts and is a no-op
+// otherwise.
+func (a *App) syncDependencies(ctx context.Context, repoDir string) error {
+	if _, err := os.Stat(filepath.Join(repoDir, "pyproject.toml")); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			a.updateLog.Warnf(a.tf("log.update_sync_skipped", repoDir))
+			return nil
+		}
+		return err
+	}
+	a.updateLog.Infof(a.t("log.update_syncing_dependencies"))
+	return execx.NewRunner().Run(ctx, "uv", []string{"sync"}, execx.Options{Dir: repoDir})
+}
+
+func writeGitReport(workspaceDir string, report gitops.Report) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	name := "git-" + strings.ToLower(string(report.Operation)) + ".json"
+	return os.WriteFile(filepath.Join(workspaceDir, name), data, 0o644)
+}
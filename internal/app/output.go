@@ -0,0 +1,50 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+func (a *App) setOutput(raw string) error {
+	switch format := strings.ToLower(strings.TrimSpace(raw)); format {
+	case "", outputTable:
+		a.output = outputTable
+	case outputJSON, outputYAML:
+		a.output = format
+	default:
+		return errors.New(a.tf("err.invalid_output", raw))
+	}
+	return nil
+}
+
+// render writes v as JSON or YAML when --output asks for it, and otherwise
+// calls table to print the human-readable form. Field names come from the
+// json and yaml struct tags, which are kept identical and stable.
+func (a *App) render(v any, table func()) error {
+	switch a.output {
+	case outputJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case outputYAML:
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return enc.Close()
+	default:
+		table()
+		return nil
+	}
+}
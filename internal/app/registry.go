@@ -0,0 +1,204 @@
+package app
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"maibot/internal/registry"
+)
+
+// workspaceSelection picks workspaces for `workspace ls` and `workspace exec`.
+// Without scan the registry is the source; paths then only narrow it down to
+// workspaces under those directories.
+type workspaceSelection struct {
+	paths    []string
+	tag      string
+	scan     bool
+	maxDepth int
+}
+
+func (a *App) registry() (*registry.Registry, error) {
+	root, err := a.dataRoot()
+	if err != nil {
+		return nil, err
+	}
+	return registry.Open(root), nil
+}
+
+// registerWorkspace records the workspace rooted at workspaceRoot. Failing to
+// register never fails the command; the workspace still works, it is only
+// missing from `workspace ls` until the next init or scan.
+func (a *App) registerWorkspace(workspaceRoot, name string, tags []string) {
+	reg, err := a.registry()
+	if err == nil {
+		err = reg.Register(registry.Entry{Path: workspaceRoot, Name: name, Tags: tags})
+	}
+	if err != nil {
+		a.log.Warnf(a.tf("log.workspace_register_failed", workspaceRoot, err))
+	}
+}
+
+func (a *App) deregisterWorkspace(workspaceRoot string) {
+	reg, err := a.registry()
+	if err == nil {
+		err = reg.Deregister(workspaceRoot)
+	}
+	if err != nil {
+		a.log.Warnf(a.tf("log.workspace_deregister_failed", workspaceRoot, err))
+	}
+}
+
+// registeredWorkspaces returns the registry entries, dropping those whose
+// workspace config no longer exists.
+func (a *App) registeredWorkspaces() ([]registry.Entry, error) {
+	reg, err := a.registry()
+	if err != nil {
+		return nil, err
+	}
+	dropped, err := reg.Prune(func(e registry.Entry) bool {
+		_, statErr := os.Stat(filepath.Join(e.Path, ".maibot", "config.json"))
+		return !os.IsNotExist(statErr)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Console logs share stdout with --output json/yaml; keep that parseable.
+	if a.output == outputTable {
+		for _, e := range dropped {
+			a.log.Infof(a.tf("log.workspace_pruned", e.Path))
+		}
+	}
+	return reg.List()
+}
+
+func (a *App) selectWorkspaces(sel workspaceSelection) ([]registry.Entry, error) {
+	var entries []registry.Entry
+	if sel.scan {
+		roots, err := scanWorkspaces(sel.paths, sel.maxDepth)
+		if err != nil {
+			return nil, err
+		}
+		for _, root := range roots {
+			a.registerWorkspace(root, readWorkspaceName(root), nil)
+		}
+		// Tags only live in the registry; a scan still works without it.
+		known := map[string]registry.Entry{}
+		if reg, err := a.registry(); err == nil {
+			if list, err := reg.List(); err == nil {
+				for _, e := range list {
+					known[e.Path] = e
+				}
+			}
+		}
+		for _, root := range roots {
+			e, ok := known[root]
+			if !ok {
+				e = registry.Entry{Path: root, Name: readWorkspaceName(root)}
+			}
+			entries = append(entries, e)
+		}
+	} else {
+		registered, err := a.registeredWorkspaces()
+		if err != nil {
+			return nil, err
+		}
+		var unmatched []string
+		if entries, unmatched, err = filterWorkspacesUnder(registered, sel.paths); err != nil {
+			return nil, err
+		}
+		if len(unmatched) > 0 {
+			return nil, errors.New(a.tf("err.workspace_not_registered", strings.Join(unmatched, ", ")))
+		}
+	}
+
+	tag := strings.TrimSpace(sel.tag)
+	if tag == "" {
+		return entries, nil
+	}
+	tagged := entries[:0]
+	for _, e := range entries {
+		if e.HasTag(tag) {
+			tagged = append(tagged, e)
+		}
+	}
+	return tagged, nil
+}
+
+// filterWorkspacesUnder keeps the entries inside one of paths and returns the
+// paths that contain no entry at all. No paths keeps every entry.
+func filterWorkspacesUnder(entries []registry.Entry, paths []string) ([]registry.Entry, []string, error) {
+	if len(paths) == 0 {
+		return entries, nil, nil
+	}
+	bases := make([]string, 0, len(paths))
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		bases = append(bases, abs)
+	}
+	matched := make([]bool, len(bases))
+	out := make([]registry.Entry, 0, len(entries))
+	for _, e := range entries {
+		kept := false
+		for i, base := range bases {
+			if rel, err := filepath.Rel(base, e.Path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				matched[i] = true
+				if !kept {
+					out = append(out, e)
+					kept = true
+				}
+			}
+		}
+	}
+	var unmatched []string
+	for i, base := range bases {
+		if !matched[i] {
+			unmatched = append(unmatched, base)
+		}
+	}
+	return out, unmatched, nil
+}
+
+func readWorkspaceName(workspaceRoot string) string {
+	cfg, err := readWorkspaceConfigByPath(filepath.Join(workspaceRoot, ".maibot", "config.json"))
+	if err != nil || cfg.Name == "" {
+		return filepath.Base(workspaceRoot)
+	}
+	return cfg.Name
+}
+
+// tagWorkspace adds or removes tags on the current workspace, registering it
+// first if needed.
+func (a *App) tagWorkspace(add, remove []string) error {
+	dir, err := a.workspaceDir(defaultName)
+	if err != nil {
+		return err
+	}
+	root := filepath.Dir(dir)
+	reg, err := a.registry()
+	if err != nil {
+		return err
+	}
+	if err := reg.Register(registry.Entry{Path: root, Name: readWorkspaceName(root)}); err != nil {
+		return err
+	}
+	entry, err := reg.Tag(root, add, remove)
+	if err != nil {
+		return err
+	}
+	tags := entry.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return a.render(tags, func() {
+		shown := strings.Join(tags, ",")
+		if shown == "" {
+			shown = "-"
+		}
+		a.instanceLog.Okf(a.tf("log.workspace_tags", shown))
+	})
+}
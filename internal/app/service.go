@@ -7,7 +7,6 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
-	"time"
 
 	kservice "github.com/kardianos/service"
 	"maibot/internal/process"
@@ -23,13 +22,14 @@ type instanceServiceProgram struct {
 func (p *instanceServiceProgram) Start(kservice.Service) error {
 	p.cmd = exec.Command(p.executable, p.args...)
 	p.cmd.Dir = p.workdir
-	logFile := filepath.Join(p.workdir, "workspace.log")
+	logFile := filepath.Join(p.workdir, workerOutName)
 	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
 	if err != nil {
 		return err
 	}
 	p.cmd.Stdout = f
 	p.cmd.Stderr = f
+	process.Detach(p.cmd)
 	if err := p.cmd.Start(); err != nil {
 		_ = f.Close()
 		return err
@@ -45,7 +45,7 @@ func (p *instanceServiceProgram) Stop(kservice.Service) error {
 	if p.cmd == nil || p.cmd.Process == nil {
 		return nil
 	}
-	return process.Stop(p.cmd.Process.Pid, 5*time.Second)
+	return process.Stop(p.cmd.Process.Pid, workerStopGrace)
 }
 
 func (a *App) serviceAction(action, _ string) error {
@@ -56,23 +56,7 @@ func (a *App) serviceAction(action, _ string) error {
 	if err := os.MkdirAll(workdir, 0o755); err != nil {
 		return err
 	}
-	exe, err := os.Executable()
-	if err != nil {
-		return err
-	}
-	prg := &instanceServiceProgram{
-		executable: exe,
-		args:       []string{instanceProc, workspaceID, defaultName},
-		workdir:    workdir,
-	}
-	serviceName := workspaceServiceName(workdir)
-	svc, err := kservice.New(prg, &kservice.Config{
-		Name:             serviceName,
-		DisplayName:      "MaiBot Workspace",
-		Description:      "MaiBot workspace service " + serviceName,
-		Arguments:        prg.args,
-		WorkingDirectory: workdir,
-	})
+	svc, serviceName, err := workspaceService(workdir)
 	if err != nil {
 		return err
 	}
@@ -99,7 +83,10 @@ func (a *App) serviceAction(action, _ string) error {
 		if err != nil {
 			return err
 		}
-		fmt.Print(a.tf("service.status_line", serviceName, status))
+		view := serviceStatusView{Service: serviceName, Status: serviceStatusName(status)}
+		return a.render(view, func() {
+			fmt.Print(a.tf("service.status_line", view.Service, view.Status))
+		})
 	default:
 		return errors.New(a.tf("err.service_unsupported_action", action))
 	}
@@ -107,6 +94,48 @@ func (a *App) serviceAction(action, _ string) error {
 	return nil
 }
 
+// workspaceService describes the system service that runs the workspace
+// whose .maibot directory is workdir.
+func workspaceService(workdir string) (kservice.Service, string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, "", err
+	}
+	prg := &instanceServiceProgram{
+		executable: exe,
+		args:       []string{instanceProc, workspaceID, defaultName},
+		workdir:    workdir,
+	}
+	serviceName := workspaceServiceName(workdir)
+	svc, err := kservice.New(prg, &kservice.Config{
+		Name:             serviceName,
+		DisplayName:      "MaiBot Workspace",
+		Description:      "MaiBot workspace service " + serviceName,
+		Arguments:        prg.args,
+		WorkingDirectory: workdir,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return svc, serviceName, nil
+}
+
+type serviceStatusView struct {
+	Service string `json:"service" yaml:"service"`
+	Status  string `json:"status" yaml:"status"`
+}
+
+func serviceStatusName(status kservice.Status) string {
+	switch status {
+	case kservice.StatusRunning:
+		return "running"
+	case kservice.StatusStopped:
+		return "stopped"
+	default:
+		return "unknown"
+	}
+}
+
 func workspaceServiceName(workdir string) string {
 	workspaceRoot := filepath.Dir(workdir)
 	base := sanitizeServiceToken(filepath.Base(workspaceRoot))
@@ -0,0 +1,147 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"maibot/internal/gitops"
+	"maibot/internal/process"
+)
+
+// statusCPUSample is how long status watches the process tree to compute a
+// CPU percentage.
+const statusCPUSample = 200 * time.Millisecond
+
+type statusView struct {
+	Workspace      string            `json:"workspace" yaml:"workspace"`
+	ID             string            `json:"id" yaml:"id"`
+	Path           string            `json:"path" yaml:"path"`
+	State          string            `json:"state" yaml:"state"`
+	PID            int               `json:"pid" yaml:"pid"`
+	StartedAt      *time.Time        `json:"started_at" yaml:"started_at"`
+	UptimeSeconds  int64             `json:"uptime_seconds" yaml:"uptime_seconds"`
+	Restarts       int               `json:"restarts" yaml:"restarts"`
+	LastExitCode   *int              `json:"last_exit_code" yaml:"last_exit_code"`
+	LastExitSignal string            `json:"last_exit_signal" yaml:"last_exit_signal"`
+	LastExitAt     *time.Time        `json:"last_exit_at" yaml:"last_exit_at"`
+	MaiBot         *maibotView       `json:"maibot" yaml:"maibot"`
+	Modules        []installedModule `json:"modules" yaml:"modules"`
+	Resources      *resourceView     `json:"resources" yaml:"resources"`
+	UpdatedAt      time.Time         `json:"updated_at" yaml:"updated_at"`
+}
+
+type maibotView struct {
+	Commit string `json:"commit" yaml:"commit"`
+	Branch string `json:"branch" yaml:"branch"`
+}
+
+// resourceView is the usage of the worker and every process below it.
+type resourceView struct {
+	Processes  int     `json:"processes" yaml:"processes"`
+	CPUPercent float64 `json:"cpu_percent" yaml:"cpu_percent"`
+	CPUSeconds float64 `json:"cpu_seconds" yaml:"cpu_seconds"`
+	RSSBytes   uint64  `json:"rss_bytes" yaml:"rss_bytes"`
+	OpenFDs    int     `json:"open_fds" yaml:"open_fds"`
+}
+
+func (a *App) statusInstance(ctx context.Context, name string) error {
+	cfg, err := a.readWorkspaceConfig(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return errors.New(a.t("err.workspace_not_initialized"))
+		}
+		return err
+	}
+
+	dir, err := a.workspaceDir(name)
+	if err != nil {
+		return err
+	}
+	cfg = reconcileWorkspace(cfg)
+	view := statusView{
+		Workspace:      cfg.Name,
+		ID:             workspaceID,
+		Path:           filepath.Dir(dir),
+		State:          cfg.Status,
+		PID:            cfg.PID,
+		StartedAt:      cfg.StartedAt,
+		Restarts:       cfg.Restarts,
+		LastExitCode:   cfg.LastExitCode,
+		LastExitSignal: cfg.LastExitSignal,
+		LastExitAt:     cfg.LastExitAt,
+		MaiBot:         a.maibotStatus(ctx, dir),
+		Modules:        append([]installedModule{}, cfg.Modules...),
+		UpdatedAt:      cfg.UpdatedAt,
+	}
+	if cfg.Status == workspaceStateRunning {
+		if cfg.StartedAt != nil {
+			view.UptimeSeconds = int64(time.Since(*cfg.StartedAt).Seconds())
+		}
+		if usage, err := process.TreeUsage(cfg.PID, statusCPUSample); err == nil {
+			view.Resources = &resourceView{
+				Processes:  usage.Processes,
+				CPUPercent: usage.CPUPercent,
+				CPUSeconds: usage.CPUSeconds,
+				RSSBytes:   usage.RSSBytes,
+				OpenFDs:    usage.OpenFDs,
+			}
+		}
+	}
+	return a.render(view, func() { printStatus(view) })
+}
+
+// maibotStatus reports the checkout in MaiBot/, or nil if there is none.
+func (a *App) maibotStatus(ctx context.Context, workspaceDir string) *maibotView {
+	repoDir := maibotDir(workspaceDir)
+	if _, err := os.Stat(filepath.Join(repoDir, ".git")); err != nil {
+		return nil
+	}
+	mgr := gitops.New(a.cfg.Git, nil)
+	commit, err := mgr.Head(ctx, repoDir)
+	if err != nil {
+		return nil
+	}
+	branch, _ := mgr.Branch(ctx, repoDir)
+	return &maibotView{Commit: commit, Branch: branch}
+}
+
+func printStatus(view statusView) {
+	fmt.Printf("workspace=%s\n", view.Workspace)
+	fmt.Printf("id=%s\n", view.ID)
+	fmt.Printf("state=%s\n", view.State)
+	fmt.Printf("pid=%d\n", view.PID)
+	if view.StartedAt != nil {
+		fmt.Printf("started_at=%s\n", view.StartedAt.Format(time.RFC3339))
+	}
+	if view.State == workspaceStateRunning {
+		fmt.Printf("uptime=%s\n", time.Duration(view.UptimeSeconds)*time.Second)
+	}
+	fmt.Printf("restarts=%d\n", view.Restarts)
+	if view.LastExitCode != nil {
+		fmt.Printf("last_exit_code=%d\n", *view.LastExitCode)
+	}
+	if view.LastExitSignal != "" {
+		fmt.Printf("last_exit_signal=%s\n", view.LastExitSignal)
+	}
+	if view.MaiBot != nil {
+		fmt.Printf("maibot_commit=%s\n", view.MaiBot.Commit)
+		fmt.Printf("maibot_branch=%s\n", view.MaiBot.Branch)
+	}
+	names := make([]string, 0, len(view.Modules))
+	for _, m := range view.Modules {
+		names = append(names, m.Name)
+	}
+	fmt.Printf("modules=%s\n", strings.Join(names, ","))
+	if r := view.Resources; r != nil {
+		fmt.Printf("processes=%d\n", r.Processes)
+		fmt.Printf("cpu_percent=%.1f\n", r.CPUPercent)
+		fmt.Printf("rss_bytes=%d\n", r.RSSBytes)
+		fmt.Printf("open_fds=%d\n", r.OpenFDs)
+	}
+	fmt.Printf("updated_at=%s\n", view.UpdatedAt.Format(time.RFC3339))
+}
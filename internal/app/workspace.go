@@ -1,37 +1,210 @@
 package app
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"sort"
 	"strings"
+	"syscall"
 	"time"
 
+	"maibot/internal/config"
+	"maibot/internal/gitops"
+	"maibot/internal/instance"
+	"maibot/internal/logging"
 	"maibot/internal/process"
+	"maibot/internal/supervisor"
 )
 
 const (
 	workspaceID             = "workspace"
-	workspaceStateInstalled = "installed"
-	workspaceStateRunning   = "running"
-	workspaceStateStopped   = "stopped"
-	workspaceStateUpdating  = "updating"
+	workspaceLogName        = "workspace.log"
+	workerOutName           = "worker.out"
+	workspaceStateInstalled = instance.StateInstalled
+	workspaceStateRunning   = instance.StateRunning
+	workspaceStateStopped   = instance.StateStopped
+	workspaceStateUpdating  = instance.StateUpdating
+	workspaceStateFailed    = instance.StateFailed
+
+	childStopGrace  = 5 * time.Second
+	workerStopGrace = childStopGrace + 5*time.Second
+
+	// configLockTimeout bounds the wait for another read-modify-write of
+	// config.json, which only ever holds the lock briefly.
+	configLockTimeout = 10 * time.Second
 )
 
+var defaultMaiBotCommand = []string{"uv", "run", "python", "bot.py"}
+
+var defaultRestart = workspaceRestart{
+	Policy:            string(supervisor.RestartOnFailure),
+	BackoffSeconds:    2,
+	MaxBackoffSeconds: 60,
+	MaxRestarts:       5,
+	WindowSeconds:     600,
+}
+
+type workspaceRestart struct {
+	Policy            string `json:"policy"`
+	BackoffSeconds    int    `json:"backoff_seconds"`
+	MaxBackoffSeconds int    `json:"max_backoff_seconds"`
+	MaxRestarts       int    `json:"max_restarts"`
+	WindowSeconds     int    `json:"window_seconds"`
+}
+
 type workspaceConfig struct {
-	Version   int       `json:"version"`
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Status    string    `json:"status"`
-	PID       int       `json:"pid"`
+	Version        int               `json:"version"`
+	Name           string            `json:"name"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+	Status         string            `json:"status"`
+	PID            int               `json:"pid"`
+	PIDStartTime   uint64            `json:"pid_start_time,omitempty"`
+	PIDExe         string            `json:"pid_exe,omitempty"`
+	Command        []string          `json:"command"`
+	Restart        workspaceRestart  `json:"restart"`
+	Restarts       int               `json:"restarts"`
+	Repo           string            `json:"repo,omitempty"`
+	Ref            string            `json:"ref,omitempty"`
+	OwnerPID       int               `json:"owner_pid,omitempty"`
+	StartedAt      *time.Time        `json:"started_at,omitempty"`
+	LastExitCode   *int              `json:"last_exit_code,omitempty"`
+	LastExitSignal string            `json:"last_exit_signal,omitempty"`
+	LastExitAt     *time.Time        `json:"last_exit_at,omitempty"`
+	Modules        []installedModule `json:"modules,omitempty"`
+	Logging        workspaceLogging  `json:"logging"`
+}
+
+// installedModule records a successful `maibot modules install`.
+type installedModule struct {
+	Name        string    `json:"name" yaml:"name"`
+	Source      string    `json:"source" yaml:"source"`
+	InstalledAt time.Time `json:"installed_at" yaml:"installed_at"`
+}
+
+// workspaceLogging caps workspace.log. Init leaves it zero so that the
+// layered logging settings apply; a value set here overrides them.
+type workspaceLogging struct {
+	MaxSizeMB      int `json:"max_size_mb,omitempty"`
+	RetentionDays  int `json:"retention_days,omitempty"`
+	MaxBackupFiles int `json:"max_backup_files,omitempty"`
+}
+
+func (c workspaceConfig) logOptions(path string, global config.Logging) logging.Options {
+	opts := logging.Options{
+		FilePath:       path,
+		MaxSizeMB:      c.Logging.MaxSizeMB,
+		RetentionDays:  c.Logging.RetentionDays,
+		MaxBackupFiles: c.Logging.MaxBackupFiles,
+	}
+	if opts.MaxSizeMB <= 0 {
+		opts.MaxSizeMB = global.MaxSizeMB
+	}
+	if opts.RetentionDays <= 0 {
+		opts.RetentionDays = global.RetentionDays
+	}
+	if opts.MaxBackupFiles <= 0 {
+		opts.MaxBackupFiles = global.MaxBackupFiles
+	}
+	return opts
+}
+
+// worker returns the recorded worker process. Checking its identity rather
+// than the bare pid keeps a reused pid from being reported alive or signalled.
+func (c workspaceConfig) worker() process.Identity {
+	return process.Identity{PID: c.PID, StartTime: c.PIDStartTime, Exe: c.PIDExe}
+}
+
+func (c *workspaceConfig) setWorker(id process.Identity) {
+	c.PID, c.PIDStartTime, c.PIDExe = id.PID, id.StartTime, id.Exe
+}
+
+func (c workspaceConfig) command() []string {
+	if len(c.Command) == 0 || strings.TrimSpace(c.Command[0]) == "" {
+		return append([]string{}, defaultMaiBotCommand...)
+	}
+	return append([]string{}, c.Command...)
+}
+
+func (c workspaceConfig) restartPolicy() (supervisor.RestartPolicy, error) {
+	r := c.Restart
+	if strings.TrimSpace(r.Policy) == "" {
+		r.Policy = defaultRestart.Policy
+	}
+	policy, err := supervisor.ParsePolicy(r.Policy)
+	if err != nil {
+		return supervisor.RestartPolicy{}, err
+	}
+	if r.BackoffSeconds <= 0 {
+		r.BackoffSeconds = defaultRestart.BackoffSeconds
+	}
+	if r.MaxBackoffSeconds <= 0 {
+		r.MaxBackoffSeconds = defaultRestart.MaxBackoffSeconds
+	}
+	if r.MaxRestarts <= 0 {
+		r.MaxRestarts = defaultRestart.MaxRestarts
+	}
+	if r.WindowSeconds <= 0 {
+		r.WindowSeconds = defaultRestart.WindowSeconds
+	}
+	return supervisor.RestartPolicy{
+		Policy:      policy,
+		Backoff:     time.Duration(r.BackoffSeconds) * time.Second,
+		MaxBackoff:  time.Duration(r.MaxBackoffSeconds) * time.Second,
+		MaxRestarts: r.MaxRestarts,
+		Window:      time.Duration(r.WindowSeconds) * time.Second,
+	}, nil
+}
+
+// reconcileWorkspace derives the effective state from the recorded one and the
+// liveness of the processes it refers to. A running workspace whose worker is
+// gone is stopped; an update whose owner is gone was interrupted and is failed.
+func reconcileWorkspace(cfg workspaceConfig) workspaceConfig {
+	alive := cfg.PID > 0 && cfg.worker().Alive()
+	if !alive {
+		cfg.setWorker(process.Identity{})
+	}
+	switch {
+	case cfg.Status == workspaceStateUpdating:
+		if cfg.OwnerPID <= 0 || !process.IsAlive(cfg.OwnerPID) {
+			cfg.Status = workspaceStateFailed
+			cfg.OwnerPID = 0
+		}
+	case alive:
+		cfg.Status = workspaceStateRunning
+	case cfg.Status == workspaceStateRunning:
+		cfg.Status = workspaceStateStopped
+	}
+	return cfg
+}
+
+// checkTransition reports whether cfg may move to state. Only the process that
+// owns an update may move the workspace out of updating.
+func (a *App) checkTransition(cfg workspaceConfig, state string) error {
+	if cfg.Status == workspaceStateUpdating && cfg.OwnerPID != os.Getpid() {
+		return errors.New(a.tf("err.workspace_busy_updating", state))
+	}
+	if err := instance.ValidateTransition(cfg.Status, state); err != nil {
+		return errors.New(a.tf("err.workspace_invalid_transition", cfg.Status, state))
+	}
+	return nil
+}
+
+// transition moves cfg to state through the instance state machine.
+func (a *App) transition(cfg *workspaceConfig, state string) error {
+	if err := a.checkTransition(*cfg, state); err != nil {
+		return err
+	}
+	cfg.Status = state
+	return nil
 }
 
 func (a *App) dataRoot() (string, error) {
@@ -119,11 +292,10 @@ func (a *App) workspaceLogPath(name string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(dir, "workspace.log"), nil
+	return filepath.Join(dir, workspaceLogName), nil
 }
 
-func (a *App) installInstance(name string) error {
-	_, _ = a, name
+func (a *App) installInstance(ctx context.Context, name string, opts initOptions) error {
 	dir, err := workspaceDirForInit()
 	if err != nil {
 		return err
@@ -135,8 +307,14 @@ func (a *App) installInstance(name string) error {
 	if err := os.MkdirAll(filepath.Join(workspaceRoot, "modules"), 0o755); err != nil {
 		return err
 	}
-	if err := os.MkdirAll(filepath.Join(workspaceRoot, "MaiBot"), 0o755); err != nil {
-		return err
+
+	repo := strings.TrimSpace(opts.repo)
+	if repo == "" {
+		repo = a.cfg.MaiBot.RepoURL
+	}
+	ref := strings.TrimSpace(opts.ref)
+	if ref == "" {
+		ref = a.cfg.MaiBot.Ref
 	}
 
 	now := time.Now().UTC()
@@ -151,6 +329,10 @@ func (a *App) installInstance(name string) error {
 		UpdatedAt: now,
 		Status:    workspaceStateInstalled,
 		PID:       0,
+		Command:   append([]string{}, defaultMaiBotCommand...),
+		Restart:   defaultRestart,
+		Repo:      repo,
+		Ref:       ref,
 	}
 
 	configPath := filepath.Join(dir, "config.json")
@@ -166,14 +348,28 @@ func (a *App) installInstance(name string) error {
 			cfg.CreatedAt = now
 		}
 		cfg.UpdatedAt = now
-		cfg.Status = workspaceStateInstalled
-		cfg.PID = 0
+		cfg = reconcileWorkspace(cfg)
+		if err := a.transition(&cfg, workspaceStateInstalled); err != nil {
+			return err
+		}
+		if len(cfg.Command) == 0 {
+			cfg.Command = append([]string{}, defaultMaiBotCommand...)
+		}
+		if strings.TrimSpace(cfg.Restart.Policy) == "" {
+			cfg.Restart = defaultRestart
+		}
+		if strings.TrimSpace(opts.repo) != "" || strings.TrimSpace(cfg.Repo) == "" {
+			cfg.Repo = repo
+		}
+		if strings.TrimSpace(opts.ref) != "" || strings.TrimSpace(cfg.Ref) == "" {
+			cfg.Ref = ref
+		}
 	}
 	if err := writeWorkspaceConfig(configPath, cfg); err != nil {
 		return err
 	}
 
-	logPath := filepath.Join(dir, "workspace.log")
+	logPath := filepath.Join(dir, workspaceLogName)
 	f, err := os.OpenFile(logPath, os.O_CREATE, 0o644)
 	if err != nil {
 		return err
@@ -181,7 +377,13 @@ func (a *App) installInstance(name string) error {
 	if err := f.Close(); err != nil {
 		return err
 	}
-	return nil
+
+	a.registerWorkspace(workspaceRoot, cfg.Name, opts.tags)
+
+	if opts.noClone {
+		return os.MkdirAll(maibotDir(dir), 0o755)
+	}
+	return a.cloneMaiBot(ctx, dir, cfg.Repo, cfg.Ref)
 }
 
 func (a *App) startInstance(name string) error {
@@ -194,12 +396,13 @@ func (a *App) startInstance(name string) error {
 		return err
 	}
 
-	if cfg.PID > 0 {
-		if process.IsAlive(cfg.PID) {
-			a.instanceLog.Infof(a.tf("log.workspace_already_running", cfg.PID))
-			return nil
-		}
-		cfg.PID = 0
+	cfg = reconcileWorkspace(cfg)
+	if cfg.Status == workspaceStateRunning {
+		a.instanceLog.Infof(a.tf("log.workspace_already_running", cfg.PID))
+		return nil
+	}
+	if err := a.transition(&cfg, workspaceStateRunning); err != nil {
+		return err
 	}
 
 	dir, err := a.workspaceDir(selected)
@@ -210,11 +413,9 @@ func (a *App) startInstance(name string) error {
 	if err != nil {
 		return err
 	}
-	logPath, err := a.workspaceLogPath(selected)
-	if err != nil {
-		return err
-	}
-	lf, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	// The worker logs to the rotating workspace.log itself; its raw output
+	// only carries what escapes the logger, such as a Go panic.
+	lf, err := os.OpenFile(filepath.Join(dir, workerOutName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
 	if err != nil {
 		return err
 	}
@@ -224,6 +425,9 @@ func (a *App) startInstance(name string) error {
 	cmd.Stdout = lf
 	cmd.Stderr = lf
 	cmd.Env = append(os.Environ(), "MAIBOT_WORKSPACE_DIR="+dir)
+	// The worker leads its own process group so stop also reaches MaiBot and
+	// anything MaiBot spawns.
+	process.Detach(cmd)
 
 	if err := cmd.Start(); err != nil {
 		_ = lf.Close()
@@ -238,17 +442,17 @@ func (a *App) startInstance(name string) error {
 		return err
 	}
 
-	cfg.Status = workspaceStateRunning
-	cfg.PID = pid
-	cfg.UpdatedAt = time.Now().UTC()
+	// Only record what start owns: the worker may already have written its
+	// start time, restarts or a failure.
+	worker := process.Lookup(pid)
 	configPath, err := a.workspaceConfigPath(selected)
 	if err != nil {
 		return err
 	}
-	if err := writeWorkspaceConfig(configPath, cfg); err != nil {
-		return err
-	}
-	return nil
+	return updateWorkspaceConfig(configPath, func(latest *workspaceConfig) {
+		latest.Status = cfg.Status
+		latest.setWorker(worker)
+	})
 }
 
 func (a *App) stopInstance(name string) error {
@@ -261,13 +465,23 @@ func (a *App) stopInstance(name string) error {
 		return err
 	}
 
+	cfg = reconcileWorkspace(cfg)
+	if err := a.checkTransition(cfg, workspaceStateStopped); err != nil {
+		return err
+	}
 	if cfg.PID > 0 {
-		if err := process.Stop(cfg.PID, 6*time.Second); err != nil {
+		if err := cfg.worker().Stop(workerStopGrace); err != nil {
 			return err
 		}
+		// The worker records the child's exit status on its way out.
+		if latest, err := a.readWorkspaceConfig(selected); err == nil {
+			cfg = reconcileWorkspace(latest)
+		}
 	}
-	cfg.Status = workspaceStateStopped
-	cfg.PID = 0
+	if err := a.transition(&cfg, workspaceStateStopped); err != nil {
+		return err
+	}
+	cfg.setWorker(process.Identity{})
 	cfg.UpdatedAt = time.Now().UTC()
 	configPath, err := a.workspaceConfigPath(selected)
 	if err != nil {
@@ -284,75 +498,185 @@ func (a *App) restartInstance(_ string) error {
 	return a.startInstance(selected)
 }
 
-func (a *App) statusInstance(name string) error {
-	cfg, err := a.readWorkspaceConfig(name)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return errors.New(a.t("err.workspace_not_initialized"))
-		}
-		return err
+func (a *App) runInstance(id string, displayName string) error {
+	interval := 15 * time.Second
+	if d, err := time.ParseDuration(strings.TrimSpace(a.cfg.Installer.InstanceTickInterval)); err == nil && d > 0 {
+		interval = d
 	}
 
-	state := cfg.Status
-	if cfg.PID > 0 && process.IsAlive(cfg.PID) {
-		state = workspaceStateRunning
-	} else if state == workspaceStateRunning {
-		state = workspaceStateStopped
+	dir := strings.TrimSpace(os.Getenv("MAIBOT_WORKSPACE_DIR"))
+	if dir == "" {
+		detected, err := a.workspaceDir(displayName)
+		if err != nil {
+			return err
+		}
+		dir = detected
 	}
-
-	fmt.Printf("workspace=%s\n", cfg.Name)
-	fmt.Printf("id=%s\n", workspaceID)
-	fmt.Printf("state=%s\n", state)
-	fmt.Printf("pid=%d\n", cfg.PID)
-	fmt.Printf("updated_at=%s\n", cfg.UpdatedAt.Format(time.RFC3339))
-	return nil
-}
-
-func (a *App) logsInstance(name string, tail int) error {
-	logPath, err := a.workspaceLogPath(name)
+	configPath := filepath.Join(dir, "config.json")
+	cfg, err := readWorkspaceConfigByPath(configPath)
 	if err != nil {
 		return err
 	}
-	data, err := os.ReadFile(logPath)
+	restart, err := cfg.restartPolicy()
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return errors.New(a.t("err.workspace_log_not_found"))
-		}
 		return err
 	}
-
-	lines := strings.Split(string(data), "\n")
-	if len(lines) > 0 && lines[len(lines)-1] == "" {
-		lines = lines[:len(lines)-1]
-	}
-	if tail <= 0 {
-		tail = 50
-	}
-	if len(lines) > tail {
-		lines = lines[len(lines)-tail:]
+	if err := a.claimWorker(dir, configPath); err != nil {
+		return err
 	}
-	for _, line := range lines {
-		_, _ = io.WriteString(os.Stdout, line+"\n")
+	logFile, err := logging.NewRotatingFile(cfg.logOptions(filepath.Join(dir, workspaceLogName), a.cfg.Logging))
+	if err != nil {
+		return err
 	}
-	return nil
-}
+	defer func() { _ = logFile.Close() }()
+	// From here on the worker's own messages go to workspace.log next to the
+	// child output instead of the console.
+	sink := logging.NewSink(logFile)
+	a.instanceLog = sink.Module("instance")
+	childOut := sink.Module("maibot").Writer()
+	defer func() { _ = childOut.Close() }()
+	childErr := sink.Module("maibot").WarnWriter()
+	defer func() { _ = childErr.Close() }()
 
-func (a *App) runInstance(id string, displayName string) {
-	interval := 15 * time.Second
-	if d, err := time.ParseDuration(strings.TrimSpace(a.cfg.Installer.InstanceTickInterval)); err == nil && d > 0 {
-		interval = d
-	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	a.instanceLog.Infof(a.tf("log.workspace_worker_started", displayName, id))
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				a.instanceLog.Infof(a.tf("log.workspace_heartbeat", displayName, id))
+			}
+		}
+	}()
+
+	sup := supervisor.New(supervisor.Options{
+		Command:   cfg.command(),
+		Dir:       filepath.Join(filepath.Dir(dir), "MaiBot"),
+		Env:       append(os.Environ(), "MAIBOT_WORKSPACE_DIR="+dir),
+		Stdout:    childOut,
+		Stderr:    childErr,
+		StopGrace: childStopGrace,
+		Restart:   restart,
+		OnExit: func(exit supervisor.Exit) {
+			err := updateWorkspaceConfig(configPath, func(cfg *workspaceConfig) {
+				code := exit.Code
+				cfg.LastExitCode = &code
+				cfg.LastExitSignal = exit.Signal
+				cfg.LastExitAt = &exit.EndedAt
+			})
+			if err != nil {
+				a.instanceLog.Warnf(a.tf("log.workspace_record_exit_failed", err))
+			}
+		},
+		OnRestart: func(int, time.Duration) {
+			err := updateWorkspaceConfig(configPath, func(cfg *workspaceConfig) {
+				cfg.Restarts++
+			})
+			if err != nil {
+				a.instanceLog.Warnf(a.tf("log.workspace_record_exit_failed", err))
+			}
+		},
+	}, a.instanceLog)
+	exit, runErr := sup.Run(ctx)
+	final := workspaceStateStopped
+	switch {
+	case errors.Is(runErr, supervisor.ErrCrashLoop):
+		final = workspaceStateFailed
+		a.instanceLog.Errorf(a.tf("log.maibot_crash_loop", restart.MaxRestarts, restart.Window))
+	case runErr != nil:
+		final = workspaceStateFailed
+		a.instanceLog.Errorf(a.tf("log.maibot_start_failed", runErr))
+	case exit.Stopped:
+		a.instanceLog.Infof(a.tf("log.maibot_stopped", exit.PID, exit.Code))
+	default:
+		a.instanceLog.Warnf(a.tf("log.maibot_exited", exit.PID, exit.Code))
+	}
+	if err := a.finishWorker(configPath, final); err != nil {
+		a.instanceLog.Warnf(a.tf("log.workspace_record_exit_failed", err))
+	}
+	return runErr
+}
 
-	for range ticker.C {
-		a.instanceLog.Infof(a.tf("log.workspace_heartbeat", displayName, id))
+// claimWorker marks the worker started. `maibot start` records the worker
+// before it runs; one started by a service manager records itself and moves
+// the workspace to running, so status, stop and start all see it. Both happen
+// under the workspace lock, which also makes this wait for a spawning start to
+// finish its own record.
+func (a *App) claimWorker(dir string, configPath string) error {
+	return a.withWorkspaceLock(dir, func() error {
+		var claimErr error
+		err := updateWorkspaceConfig(configPath, func(cfg *workspaceConfig) {
+			if cfg.PID != os.Getpid() {
+				current := reconcileWorkspace(*cfg)
+				if current.Status == workspaceStateRunning {
+					claimErr = errors.New(a.tf("err.workspace_worker_running", current.PID))
+					return
+				}
+				if claimErr = a.transition(&current, workspaceStateRunning); claimErr != nil {
+					return
+				}
+				current.setWorker(process.Lookup(os.Getpid()))
+				*cfg = current
+			}
+			now := time.Now().UTC()
+			cfg.StartedAt = &now
+			cfg.Restarts = 0
+		})
+		if err != nil {
+			return err
+		}
+		return claimErr
+	})
+}
+
+// finishWorker records how the worker ended. A failed workspace is always
+// marked failed; otherwise the state is only touched while this worker is
+// still the recorded instance.
+func (a *App) finishWorker(configPath string, final string) error {
+	return updateWorkspaceConfig(configPath, func(cfg *workspaceConfig) {
+		own := cfg.PID == os.Getpid()
+		if final != workspaceStateFailed && !own {
+			return
+		}
+		if err := instance.ValidateTransition(cfg.Status, final); err != nil {
+			a.instanceLog.Warnf(a.tf("log.workspace_state_unchanged", err))
+			return
+		}
+		cfg.Status = final
+		if own {
+			cfg.setWorker(process.Identity{})
+		}
+	})
+}
+
+// recordModule notes an installed module in the enclosing workspace, if any.
+func (a *App) recordModule(name, source string) error {
+	dir, found, err := detectWorkspaceDir()
+	if err != nil || !found {
+		return err
 	}
+	return updateWorkspaceConfig(filepath.Join(dir, "config.json"), func(cfg *workspaceConfig) {
+		entry := installedModule{Name: name, Source: source, InstalledAt: time.Now().UTC()}
+		for i := range cfg.Modules {
+			if cfg.Modules[i].Name == name {
+				cfg.Modules[i] = entry
+				return
+			}
+		}
+		cfg.Modules = append(cfg.Modules, entry)
+	})
 }
 
-func (a *App) updateInstance(name string) error {
+// updateInstance pulls MaiBot and re-syncs its dependencies. The instance is
+// stopped for the duration; on any failure the checkout is reset to the commit
+// recorded before the update so the old version can be restarted.
+func (a *App) updateInstance(ctx context.Context, name string) error {
 	cfg, err := a.readWorkspaceConfig(name)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
@@ -360,18 +684,87 @@ func (a *App) updateInstance(name string) error {
 		}
 		return err
 	}
-	cfg.Status = workspaceStateUpdating
-	cfg.UpdatedAt = time.Now().UTC()
-	configPath, err := a.workspaceConfigPath(name)
+	dir, err := a.workspaceDir(name)
 	if err != nil {
 		return err
 	}
-	if err := writeWorkspaceConfig(configPath, cfg); err != nil {
+	configPath := filepath.Join(dir, "config.json")
+	repoDir := maibotDir(dir)
+
+	cfg = reconcileWorkspace(cfg)
+	if err := a.checkTransition(cfg, workspaceStateUpdating); err != nil {
 		return err
 	}
-	cfg.Status = workspaceStateInstalled
+	wasRunning := cfg.Status == workspaceStateRunning
+	settled := workspaceStateStopped
+	if cfg.Status == workspaceStateInstalled {
+		settled = workspaceStateInstalled
+	}
+	if wasRunning {
+		a.updateLog.Infof(a.t("log.update_stopping_instance"))
+		if err := a.stopInstance(name); err != nil {
+			return err
+		}
+		if cfg, err = a.readWorkspaceConfig(name); err != nil {
+			return err
+		}
+	}
+	if err := a.transition(&cfg, workspaceStateUpdating); err != nil {
+		return err
+	}
+	cfg.OwnerPID = os.Getpid()
 	cfg.UpdatedAt = time.Now().UTC()
-	return writeWorkspaceConfig(configPath, cfg)
+	if err := writeWorkspaceConfig(configPath, cfg); err != nil {
+		return err
+	}
+
+	mgr := gitops.New(a.cfg.Git, a.gitLog)
+	previous, err := mgr.Head(ctx, repoDir)
+	if err != nil {
+		updateErr := errors.New(a.tf("err.update_head_failed", err))
+		return a.settleUpdate(configPath, settled, wasRunning, name, updateErr)
+	}
+	a.updateLog.Infof(a.tf("log.update_recorded_commit", previous))
+
+	updateErr := a.pullAndSync(ctx, mgr, dir, repoDir)
+	if updateErr != nil {
+		a.updateLog.Errorf(a.tf("log.update_rolling_back", previous, updateErr))
+		if err := mgr.Reset(ctx, repoDir, previous); err != nil {
+			updateErr = errors.New(a.tf("err.update_rollback_failed", updateErr, err))
+			return a.settleUpdate(configPath, workspaceStateFailed, false, name, updateErr)
+		}
+		if err := a.syncDependencies(ctx, repoDir); err != nil {
+			a.updateLog.Warnf(a.tf("log.update_rollback_sync_failed", err))
+		}
+		a.updateLog.Warnf(a.tf("log.update_rolled_back", previous))
+	} else if current, err := mgr.Head(ctx, repoDir); err == nil {
+		a.updateLog.Infof(a.tf("log.update_commit_changed", previous, current))
+	}
+	return a.settleUpdate(configPath, settled, wasRunning, name, updateErr)
+}
+
+// settleUpdate leaves the updating state and restarts the instance if it was
+// running before the update, returning updateErr (or the restart error).
+func (a *App) settleUpdate(configPath string, state string, restart bool, name string, updateErr error) error {
+	var transitionErr error
+	err := updateWorkspaceConfig(configPath, func(cfg *workspaceConfig) {
+		transitionErr = a.transition(cfg, state)
+		cfg.OwnerPID = 0
+	})
+	if err == nil {
+		err = transitionErr
+	}
+	if err != nil {
+		return errors.Join(updateErr, err)
+	}
+	if !restart {
+		return updateErr
+	}
+	a.updateLog.Infof(a.t("log.update_restarting_instance"))
+	if err := a.startInstance(name); err != nil {
+		return errors.Join(updateErr, err)
+	}
+	return updateErr
 }
 
 func (a *App) readWorkspaceConfig(name string) (workspaceConfig, error) {
@@ -397,13 +790,38 @@ func readWorkspaceConfigByPath(configPath string) (workspaceConfig, error) {
 	return cfg, nil
 }
 
+// updateWorkspaceConfig applies mutate to the current config.json. The
+// read-modify-write holds a lock of its own next to the file, so that the
+// worker's updates and a command's cannot overwrite each other. It is not the
+// workspace lock: stop holds that while the worker records its exit.
+func updateWorkspaceConfig(path string, mutate func(*workspaceConfig)) error {
+	lock, err := instance.AcquireLock(filepath.Dir(path), "config", configLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = lock.Release() }()
+	cfg, err := readWorkspaceConfigByPath(path)
+	if err != nil {
+		return err
+	}
+	mutate(&cfg)
+	cfg.UpdatedAt = time.Now().UTC()
+	return writeWorkspaceConfig(path, cfg)
+}
+
 func writeWorkspaceConfig(path string, cfg workspaceConfig) error {
 	data, err := json.MarshalIndent(cfg, "", "  ")
 	if err != nil {
 		return err
 	}
 	data = append(data, '\n')
-	return os.WriteFile(path, data, 0o644)
+	// Write through a temp file so readers never observe a partial config
+	// while the worker and a locked command both update it.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
 }
 
 func sha256Hex(in []byte) string {
@@ -411,9 +829,20 @@ func sha256Hex(in []byte) string {
 	return hex.EncodeToString(sum[:])
 }
 
-func (a *App) listWorkspaces(roots []string, maxDepth int) error {
-	if len(roots) == 0 {
-		roots = []string{"."}
+type workspaceRow struct {
+	Name    string   `json:"name" yaml:"name"`
+	Path    string   `json:"path" yaml:"path"`
+	State   string   `json:"state" yaml:"state"`
+	PID     int      `json:"pid" yaml:"pid"`
+	Service string   `json:"service" yaml:"service"`
+	Tags    []string `json:"tags" yaml:"tags"`
+	Current bool     `json:"current" yaml:"current"`
+}
+
+func (a *App) listWorkspaces(sel workspaceSelection) error {
+	entries, err := a.selectWorkspaces(sel)
+	if err != nil {
+		return err
 	}
 
 	currentMarker := ""
@@ -421,23 +850,62 @@ func (a *App) listWorkspaces(roots []string, maxDepth int) error {
 		currentMarker = curDir
 	}
 
-	type row struct {
-		name string
-		root string
-	}
-	rows := make([]row, 0)
-	seen := map[string]bool{}
-	processWorkspace := func(workspaceRoot string) {
-		if seen[workspaceRoot] {
-			return
-		}
+	rows := make([]workspaceRow, 0, len(entries))
+	for _, entry := range entries {
+		workspaceRoot := entry.Path
 		cfgPath := filepath.Join(workspaceRoot, ".maibot", "config.json")
 		cfg, cfgErr := readWorkspaceConfigByPath(cfgPath)
 		if cfgErr != nil {
 			cfg = workspaceConfig{Name: filepath.Base(workspaceRoot)}
 		}
-		rows = append(rows, row{name: cfg.Name, root: workspaceRoot})
-		seen[workspaceRoot] = true
+		cfg = reconcileWorkspace(cfg)
+		rows = append(rows, workspaceRow{
+			Name:    cfg.Name,
+			Path:    workspaceRoot,
+			State:   cfg.Status,
+			PID:     cfg.PID,
+			Service: workspaceServiceName(filepath.Join(workspaceRoot, ".maibot")),
+			Tags:    append([]string{}, entry.Tags...),
+			Current: currentMarker != "" && filepath.Join(workspaceRoot, ".maibot") == currentMarker,
+		})
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Name == rows[j].Name {
+			return rows[i].Path < rows[j].Path
+		}
+		return rows[i].Name < rows[j].Name
+	})
+
+	return a.render(rows, func() {
+		if len(rows) == 0 {
+			fmt.Println(a.t("help.no_workspace_found"))
+			return
+		}
+		for _, r := range rows {
+			marker := " "
+			if r.Current {
+				marker = "*"
+			}
+			state := r.State
+			if state == "" {
+				state = "-"
+			}
+			tags := strings.Join(r.Tags, ",")
+			if tags == "" {
+				tags = "-"
+			}
+			fmt.Printf("%s %s\t%s\t%s\t%s\t%s\n", marker, r.Name, state, r.Service, tags, r.Path)
+		}
+	})
+}
+
+// scanWorkspaces walks paths breadth first, up to maxDepth levels, and returns
+// every directory that holds a .maibot directory. Hidden and build directories
+// are skipped.
+func scanWorkspaces(paths []string, maxDepth int) ([]string, error) {
+	if len(paths) == 0 {
+		paths = []string{"."}
 	}
 
 	type scanNode struct {
@@ -445,10 +913,12 @@ func (a *App) listWorkspaces(roots []string, maxDepth int) error {
 		depth int
 	}
 
-	for _, root := range roots {
+	var found []string
+	seen := map[string]bool{}
+	for _, root := range paths {
 		absRoot, err := filepath.Abs(root)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		queue := []scanNode{{path: absRoot, depth: 0}}
@@ -458,7 +928,7 @@ func (a *App) listWorkspaces(roots []string, maxDepth int) error {
 
 			entries, err := os.ReadDir(node.path)
 			if err != nil {
-				return err
+				return nil, err
 			}
 
 			hasWorkspace := false
@@ -469,7 +939,10 @@ func (a *App) listWorkspaces(roots []string, maxDepth int) error {
 				}
 			}
 			if hasWorkspace {
-				processWorkspace(node.path)
+				if !seen[node.path] {
+					seen[node.path] = true
+					found = append(found, node.path)
+				}
 				continue
 			}
 
@@ -489,24 +962,5 @@ func (a *App) listWorkspaces(roots []string, maxDepth int) error {
 			}
 		}
 	}
-
-	sort.Slice(rows, func(i, j int) bool {
-		if rows[i].name == rows[j].name {
-			return rows[i].root < rows[j].root
-		}
-		return rows[i].name < rows[j].name
-	})
-
-	if len(rows) == 0 {
-		fmt.Println(a.t("help.no_workspace_found"))
-		return nil
-	}
-	for _, r := range rows {
-		marker := " "
-		if currentMarker != "" && filepath.Join(r.root, ".maibot") == currentMarker {
-			marker = "*"
-		}
-		fmt.Printf("%s %s\t%s\n", marker, r.name, r.root)
-	}
-	return nil
+	return found, nil
 }
@@ -0,0 +1,142 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// workspaceExecActions are the lifecycle commands `workspace exec` can run.
+var workspaceExecActions = []string{"start", "stop", "restart", "status", "update"}
+
+const defaultExecParallel = 4
+
+type workspaceExecResult struct {
+	Name            string  `json:"name" yaml:"name"`
+	Path            string  `json:"path" yaml:"path"`
+	Action          string  `json:"action" yaml:"action"`
+	OK              bool    `json:"ok" yaml:"ok"`
+	ExitCode        int     `json:"exit_code" yaml:"exit_code"`
+	Error           string  `json:"error,omitempty" yaml:"error,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds" yaml:"duration_seconds"`
+	Output          string  `json:"output" yaml:"output"`
+}
+
+// execWorkspaces runs action in every selected workspace, at most parallel at
+// a time. Each run is a separate `maibot -C <path> <action>` process, so the
+// usual workspace lock and state checks apply and one workspace failing does
+// not stop the others.
+func (a *App) execWorkspaces(ctx context.Context, action string, sel workspaceSelection, all bool, parallel int) error {
+	if !validExecAction(action) {
+		return errors.New(a.tf("err.workspace_exec_invalid_action", action, strings.Join(workspaceExecActions, ", ")))
+	}
+	if !all && strings.TrimSpace(sel.tag) == "" && len(sel.paths) == 0 {
+		return errors.New(a.t("err.workspace_exec_no_target"))
+	}
+	entries, err := a.selectWorkspaces(sel)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return errors.New(a.t("help.no_workspace_found"))
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if parallel <= 0 {
+		parallel = defaultExecParallel
+	}
+
+	results := make([]workspaceExecResult, len(entries))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, entry := range entries {
+		wg.Add(1)
+		go func(i int, name, path string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = runWorkspaceAction(ctx, exe, name, path, action, a.output)
+		}(i, entry.Name, entry.Path)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, r := range results {
+		if !r.OK {
+			failed++
+		}
+	}
+	err = a.render(results, func() {
+		for _, r := range results {
+			if strings.TrimSpace(r.Output) == "" {
+				continue
+			}
+			fmt.Printf("==> %s (%s)\n%s\n", r.Name, r.Path, strings.TrimRight(r.Output, "\n"))
+		}
+		fmt.Println()
+		for _, r := range results {
+			result := "ok"
+			if !r.OK {
+				result = "failed"
+			}
+			fmt.Printf("%s\t%s\t%s\t%.1fs\t%s\n", result, r.Name, r.Path, r.DurationSeconds, r.Error)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return errors.New(a.tf("err.workspace_exec_failed", failed, len(results), action))
+	}
+	return nil
+}
+
+func validExecAction(action string) bool {
+	for _, a := range workspaceExecActions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// runWorkspaceAction runs one child with the parent's output format, so
+// `-o json workspace exec` collects JSON from every workspace.
+func runWorkspaceAction(ctx context.Context, exe, name, path, action, output string) workspaceExecResult {
+	result := workspaceExecResult{Name: name, Path: path, Action: action}
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, exe, "--output", output, "-C", path, action)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	started := time.Now()
+	err := cmd.Run()
+	result.DurationSeconds = time.Since(started).Seconds()
+	result.Output = out.String()
+	if err == nil {
+		result.OK = true
+		return result
+	}
+	result.ExitCode = -1
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+	}
+	result.Error = lastLine(result.Output)
+	if result.Error == "" {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimRight(s, "\r\n"), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -0,0 +1,436 @@
+// Package backup writes and restores workspace archives: a zstd-compressed
+// tar holding a manifest, files below the workspace root and, optionally,
+// files below the user's home directory that modules keep state in.
+package backup
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	ManifestName    = "manifest.json"
+	manifestVersion = 1
+
+	workspacePrefix = "workspace/"
+	homePrefix      = "home/"
+)
+
+// ErrNoManifest is returned for archives that do not start with a manifest.
+var ErrNoManifest = errors.New("archive has no manifest")
+
+// Manifest describes what an archive was taken from. It is always the first
+// entry so it can be read without unpacking the rest.
+type Manifest struct {
+	Version          int               `json:"version"`
+	CreatedAt        time.Time         `json:"created_at"`
+	InstallerVersion string            `json:"installer_version"`
+	Workspace        ManifestWorkspace `json:"workspace"`
+	MaiBot           ManifestMaiBot    `json:"maibot"`
+	Modules          []string          `json:"modules,omitempty"`
+	Service          ManifestService   `json:"service"`
+}
+
+type ManifestWorkspace struct {
+	Name string   `json:"name"`
+	Path string   `json:"path"`
+	Tags []string `json:"tags,omitempty"`
+}
+
+type ManifestMaiBot struct {
+	Repo   string `json:"repo"`
+	Ref    string `json:"ref"`
+	Commit string `json:"commit,omitempty"`
+}
+
+type ManifestService struct {
+	Name      string `json:"name"`
+	Installed bool   `json:"installed"`
+}
+
+// Source lists what goes into an archive. Paths are relative to Root; a path
+// starting with "~/" is relative to Home instead. Missing paths are skipped.
+// Skip, when set, is called with the slash-separated path relative to Root or
+// Home and excludes that file or whole directory when it returns true.
+type Source struct {
+	Root  string
+	Home  string
+	Paths []string
+	Skip  func(rel string, isDir bool) bool
+}
+
+// Stats counts what Write stored or Extract restored.
+type Stats struct {
+	Files   int   `json:"files"`
+	Bytes   int64 `json:"bytes"`
+	Skipped int   `json:"skipped"`
+}
+
+// Write streams the manifest and every file selected by src to w.
+func Write(w io.Writer, m Manifest, src Source) (Stats, error) {
+	var stats Stats
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return stats, err
+	}
+	tw := tar.NewWriter(zw)
+
+	m.Version = manifestVersion
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return stats, err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: ManifestName, Mode: 0o644, Size: int64(len(data)), ModTime: m.CreatedAt, Typeflag: tar.TypeReg}); err != nil {
+		return stats, err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return stats, err
+	}
+
+	for _, p := range src.Paths {
+		base, prefix, rel := src.Root, workspacePrefix, p
+		if strings.HasPrefix(p, "~/") {
+			if src.Home == "" {
+				continue
+			}
+			base, prefix, rel = src.Home, homePrefix, strings.TrimPrefix(p, "~/")
+		}
+		if err := addTree(tw, base, filepath.FromSlash(rel), prefix, src.Skip, &stats); err != nil {
+			return stats, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return stats, err
+	}
+	return stats, zw.Close()
+}
+
+func addTree(tw *tar.Writer, base, rel, prefix string, skip func(string, bool) bool, stats *Stats) error {
+	root := filepath.Join(base, rel)
+	if _, err := os.Lstat(root); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		r, err := filepath.Rel(base, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(r)
+		if skip != nil && skip(name, d.IsDir()) {
+			stats.Skipped++
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		} else if !info.Mode().IsRegular() && !info.IsDir() {
+			// Sockets, pipes and devices cannot be restored meaningfully.
+			stats.Skipped++
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = prefix + name
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uname, hdr.Gname = "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(tw, f)
+		_ = f.Close()
+		if err != nil {
+			return err
+		}
+		stats.Files++
+		stats.Bytes += n
+		return nil
+	})
+}
+
+// ReadManifest returns the manifest of the archive at path.
+func ReadManifest(archive string) (Manifest, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer func() { _ = f.Close() }()
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer zr.Close()
+	return readManifest(tar.NewReader(zr))
+}
+
+func readManifest(tr *tar.Reader) (Manifest, error) {
+	hdr, err := tr.Next()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return Manifest{}, ErrNoManifest
+		}
+		return Manifest{}, err
+	}
+	if hdr.Name != ManifestName {
+		return Manifest{}, ErrNoManifest
+	}
+	var m Manifest
+	if err := json.NewDecoder(tr).Decode(&m); err != nil {
+		return Manifest{}, fmt.Errorf("read manifest: %w", err)
+	}
+	if m.Version > manifestVersion {
+		return Manifest{}, fmt.Errorf("archive version %d is newer than supported version %d", m.Version, manifestVersion)
+	}
+	return m, nil
+}
+
+// Target says where Extract unpacks an archive. Workspace entries go below
+// Root, overwriting what is there. Home entries go below Home, never replacing
+// an existing file, and only at or below one of Paths, given like the "~/"
+// paths of Source; without Home they are skipped.
+type Target struct {
+	Root  string
+	Home  string
+	Paths []string
+}
+
+// pendingLink is a symlink entry held back until every other entry is
+// written, so that no entry is extracted through a link from the archive.
+type pendingLink struct {
+	hdr       *tar.Header
+	base, top string
+	target    string
+	overwrite bool
+}
+
+// Extract unpacks the archive into dst.
+func Extract(archive string, dst Target) (Stats, error) {
+	var stats Stats
+	f, err := os.Open(archive)
+	if err != nil {
+		return stats, err
+	}
+	defer func() { _ = f.Close() }()
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		return stats, err
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+	if _, err := readManifest(tr); err != nil {
+		return stats, err
+	}
+
+	var links []pendingLink
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return stats, err
+		}
+		var base, top, rel string
+		overwrite := true
+		switch {
+		case strings.HasPrefix(hdr.Name, workspacePrefix):
+			base, rel = dst.Root, strings.TrimPrefix(hdr.Name, workspacePrefix)
+			top = base
+		case strings.HasPrefix(hdr.Name, homePrefix) && dst.Home != "":
+			base, rel, overwrite = dst.Home, strings.TrimPrefix(hdr.Name, homePrefix), false
+			state, ok := homeStatePath(dst.Paths, rel)
+			if !ok {
+				stats.Skipped++
+				continue
+			}
+			top = filepath.Join(base, filepath.FromSlash(state))
+		default:
+			stats.Skipped++
+			continue
+		}
+		target, ok := safeJoin(base, rel)
+		if !ok {
+			return stats, fmt.Errorf("archive entry %q escapes the target directory", hdr.Name)
+		}
+		if hdr.Typeflag == tar.TypeSymlink {
+			links = append(links, pendingLink{hdr: hdr, base: base, top: top, target: target, overwrite: overwrite})
+			continue
+		}
+		if err := checkParents(top, target); err != nil {
+			return stats, fmt.Errorf("archive entry %q: %w", hdr.Name, err)
+		}
+		if err := extractEntry(tr, hdr, base, target, overwrite, &stats); err != nil {
+			return stats, err
+		}
+	}
+	for _, l := range links {
+		if err := checkParents(l.top, l.target); err != nil {
+			return stats, fmt.Errorf("archive entry %q: %w", l.hdr.Name, err)
+		}
+		if err := extractEntry(nil, l.hdr, l.base, l.target, l.overwrite, &stats); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// homeStatePath returns the entry of paths that the home entry rel lies at or
+// below, without its "~/" prefix.
+func homeStatePath(paths []string, rel string) (string, bool) {
+	rel = strings.TrimSuffix(rel, "/")
+	for _, p := range paths {
+		if !strings.HasPrefix(p, "~/") {
+			continue
+		}
+		p = path.Clean(strings.TrimPrefix(p, "~/"))
+		if p == "." || p == ".." || strings.HasPrefix(p, "../") {
+			continue
+		}
+		if rel == p || strings.HasPrefix(rel, p+"/") {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// checkParents refuses a target below a symbolic link, which could point the
+// write anywhere. Only the directories between top and target are checked;
+// top and what lies above it are the user's own.
+func checkParents(top, target string) error {
+	rel, err := filepath.Rel(top, filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil
+	}
+	dir := top
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, part)
+		st, err := os.Lstat(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if st.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s is a symbolic link", dir)
+		}
+	}
+	return nil
+}
+
+func extractEntry(tr *tar.Reader, hdr *tar.Header, base, target string, overwrite bool, stats *Stats) error {
+	mode := hdr.FileInfo().Mode()
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(target, mode.Perm()|0o700)
+	case tar.TypeSymlink:
+		// Only links that stay inside the restored tree; anything else could
+		// be used to write outside of it.
+		if filepath.IsAbs(hdr.Linkname) {
+			stats.Skipped++
+			return nil
+		}
+		rel, err := filepath.Rel(base, filepath.Join(filepath.Dir(target), hdr.Linkname))
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			stats.Skipped++
+			return nil
+		}
+		if _, err := os.Lstat(target); err == nil {
+			if !overwrite {
+				stats.Skipped++
+				return nil
+			}
+			if err := os.Remove(target); err != nil {
+				return err
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		return os.Symlink(hdr.Linkname, target)
+	case tar.TypeReg:
+		if st, err := os.Lstat(target); err == nil {
+			if !overwrite {
+				stats.Skipped++
+				return nil
+			}
+			if st.Mode()&os.ModeSymlink != 0 {
+				if err := os.Remove(target); err != nil {
+					return err
+				}
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode.Perm())
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(out, tr)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		_ = os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+		stats.Files++
+		stats.Bytes += n
+		return nil
+	default:
+		stats.Skipped++
+		return nil
+	}
+}
+
+// safeJoin joins a slash-separated archive path to base and rejects absolute
+// paths and paths that climb out of base.
+func safeJoin(base, rel string) (string, bool) {
+	if strings.HasPrefix(rel, "/") || strings.Contains(rel, "\\") {
+		return "", false
+	}
+	for _, part := range strings.Split(rel, "/") {
+		if part == ".." {
+			return "", false
+		}
+	}
+	return filepath.Join(base, filepath.FromSlash(rel)), true
+}
@@ -0,0 +1,163 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func TestWriteAndExtractRoundTrip(t *testing.T) {
+	src, home := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(src, ".maibot", "config.json"), "{}")
+	writeFile(t, filepath.Join(src, "MaiBot", "config.toml"), "token")
+	writeFile(t, filepath.Join(src, "MaiBot", ".venv", "bin", "python"), "x")
+	writeFile(t, filepath.Join(home, ".config", "QQ", "login"), "session")
+	writeFile(t, filepath.Join(home, ".config", "QQ", "cache", "db"), "cached")
+
+	archive := filepath.Join(t.TempDir(), "ws.tar.zst")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatalf("create archive: %v", err)
+	}
+	m := Manifest{CreatedAt: time.Now().UTC(), Workspace: ManifestWorkspace{Name: "main", Path: src}}
+	stats, err := Write(f, m, Source{
+		Root:  src,
+		Home:  home,
+		Paths: []string{".maibot", "MaiBot", "modules/missing", "~/.config/QQ"},
+		Skip:  func(rel string, isDir bool) bool { return isDir && filepath.Base(rel) == ".venv" },
+	})
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	if stats.Files != 4 || stats.Skipped != 1 {
+		t.Fatalf("unexpected write stats: %+v", stats)
+	}
+
+	got, err := ReadManifest(archive)
+	if err != nil || got.Workspace.Name != "main" || got.Version != manifestVersion {
+		t.Fatalf("ReadManifest = %+v, %v", got, err)
+	}
+
+	dst, newHome := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(newHome, ".config", "QQ", "login"), "current")
+	if _, err := Extract(archive, Target{Root: dst, Home: newHome, Paths: []string{"~/.config/QQ"}}); err != nil {
+		t.Fatalf("Extract error: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dst, "MaiBot", "config.toml")); string(data) != "token" {
+		t.Fatalf("config.toml = %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "MaiBot", ".venv")); !os.IsNotExist(err) {
+		t.Fatalf("skipped .venv was restored: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(newHome, ".config", "QQ", "login")); string(data) != "current" {
+		t.Fatalf("existing home file was overwritten: %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(newHome, ".config", "QQ", "cache", "db")); string(data) != "cached" {
+		t.Fatalf("home state file = %q", data)
+	}
+
+	// Home entries outside the declared state paths are not restored.
+	otherHome := t.TempDir()
+	stats, err = Extract(archive, Target{Root: t.TempDir(), Home: otherHome, Paths: []string{"~/.config/QQ/cache"}})
+	if err != nil {
+		t.Fatalf("Extract error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(otherHome, ".config", "QQ", "login")); !os.IsNotExist(err) {
+		t.Fatalf("undeclared home entry was restored: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(otherHome, ".config", "QQ", "cache", "db")); string(data) != "cached" || stats.Skipped == 0 {
+		t.Fatalf("declared home entry = %q, stats %+v", data, stats)
+	}
+}
+
+type testEntry struct {
+	name, link, body string
+}
+
+func writeTestArchive(t *testing.T, entries ...testEntry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw, _ := zstd.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	manifest := []byte(`{"version":1}`)
+	_ = tw.WriteHeader(&tar.Header{Name: ManifestName, Mode: 0o644, Size: int64(len(manifest)), Typeflag: tar.TypeReg})
+	_, _ = tw.Write(manifest)
+	for _, e := range entries {
+		if e.link != "" {
+			_ = tw.WriteHeader(&tar.Header{Name: e.name, Linkname: e.link, Mode: 0o777, Typeflag: tar.TypeSymlink})
+			continue
+		}
+		_ = tw.WriteHeader(&tar.Header{Name: e.name, Mode: 0o644, Size: int64(len(e.body)), Typeflag: tar.TypeReg})
+		_, _ = tw.Write([]byte(e.body))
+	}
+	_ = tw.Close()
+	_ = zw.Close()
+
+	archive := filepath.Join(t.TempDir(), "evil.tar.zst")
+	if err := os.WriteFile(archive, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("write archive: %v", err)
+	}
+	return archive
+}
+
+func TestExtractRejectsEscapingEntries(t *testing.T) {
+	archive := writeTestArchive(t, testEntry{name: "workspace/../evil", body: "x"})
+	dst := filepath.Join(t.TempDir(), "ws")
+	_, err := Extract(archive, Target{Root: dst})
+	if err == nil || !strings.Contains(err.Error(), "escapes") {
+		t.Fatalf("Extract error = %v, want escape rejection", err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dst), "evil")); !os.IsNotExist(err) {
+		t.Fatalf("escaping entry was written: %v", err)
+	}
+}
+
+func TestExtractRefusesWritesThroughSymlinks(t *testing.T) {
+	cases := map[string][]testEntry{
+		// With a -> . and a/l -> .., l/evil would land next to the root.
+		"file below link": {
+			{name: "workspace/a", link: "."},
+			{name: "workspace/a/l", link: ".."},
+			{name: "workspace/l/evil", body: "x"},
+		},
+		// a -> . makes a/l land in the root, pointing at its parent.
+		"link below link": {
+			{name: "workspace/a", link: "."},
+			{name: "workspace/a/l", link: ".."},
+		},
+	}
+	for name, entries := range cases {
+		t.Run(name, func(t *testing.T) {
+			parent := t.TempDir()
+			dst := filepath.Join(parent, "ws")
+			if _, err := Extract(writeTestArchive(t, entries...), Target{Root: dst}); err == nil {
+				t.Fatal("Extract succeeded, want symlink rejection")
+			}
+			if _, err := os.Stat(filepath.Join(parent, "evil")); !os.IsNotExist(err) {
+				t.Fatalf("entry was written outside the root: %v", err)
+			}
+			if st, err := os.Lstat(filepath.Join(dst, "l")); err == nil && st.Mode()&os.ModeSymlink != 0 {
+				t.Fatal("link through a link was created")
+			}
+		})
+	}
+}
@@ -2,21 +2,23 @@ package config
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
-
-	koanfjson "github.com/knadh/koanf/parsers/json"
-	"github.com/knadh/koanf/providers/env"
-	"github.com/knadh/koanf/providers/file"
-	"github.com/knadh/koanf/v2"
 )
 
 const schemaVersion = 3
 
+const (
+	// defaultConfigName is the global config maibot creates.
+	defaultConfigName = "maibot.conf"
+	// legacyConfigName is the global config before maibot.conf; it is only
+	// read to migrate it.
+	legacyConfigName = "config.json"
+)
+
 type Installer struct {
 	Repo                 string `json:"repo"`
 	ReleaseChannel       string `json:"release_channel"`
@@ -24,6 +26,9 @@ type Installer struct {
 	DataHome             string `json:"data_home"`
 	InstanceTickInterval string `json:"instance_tick_interval"`
 	LockTimeoutSeconds   int    `json:"lock_timeout_seconds"`
+	// ConfigBackups is how many config.backup.*.json files migrations and
+	// restores keep.
+	ConfigBackups int `json:"config_backups"`
 }
 
 type Logging struct {
@@ -71,6 +76,16 @@ type ModuleDefinition struct {
 	Name        string       `json:"name"`
 	Description string       `json:"description"`
 	Install     []ModuleStep `json:"install"`
+	// State lists files and directories the module keeps its own state in,
+	// relative to the workspace root or, with a "~/" prefix, to the home
+	// directory. Workspace backups include them.
+	State []string `json:"state,omitempty"`
+	// Downloads lists installer downloads and scratch files, relative to the
+	// workspace root, that `cleanup --downloads` may delete.
+	Downloads []string `json:"downloads,omitempty"`
+	// Ports lists TCP ports the module listens on by default; doctor checks
+	// them for conflicts.
+	Ports []int `json:"ports,omitempty"`
 }
 
 type Modules struct {
@@ -81,6 +96,11 @@ type Modules struct {
 	PreferCatalogSource bool     `json:"prefer_catalog_source"`
 }
 
+type MaiBot struct {
+	RepoURL string `json:"repo_url"`
+	Ref     string `json:"ref"`
+}
+
 type Config struct {
 	Version   int       `json:"version"`
 	Installer Installer `json:"installer"`
@@ -89,52 +109,62 @@ type Config struct {
 	Mirrors   Mirrors   `json:"mirrors"`
 	Git       Git       `json:"git"`
 	Modules   Modules   `json:"modules"`
+	MaiBot    MaiBot    `json:"maibot"`
 }
 
+// LoadOrCreate returns the effective global config, creating ~/.maibot/maibot.conf
+// or migrating the existing file first when needed.
 func LoadOrCreate() (Config, error) {
-	base, err := resolveBaseDir()
+	if _, err := Migrate(); err != nil {
+		return Config{}, err
+	}
+	loaded, err := Load("")
 	if err != nil {
 		return Config{}, err
 	}
-	path := filepath.Join(base, "maibot.conf")
-	legacyPath := filepath.Join(base, "config.json")
+	return loaded.Config, nil
+}
 
-	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
-		if _, legacyErr := os.Stat(legacyPath); legacyErr == nil {
-			cfg, loadErr := loadFromPath(legacyPath)
-			if loadErr != nil {
-				return Config{}, loadErr
-			}
-			cfg, migrateErr := migrate(cfg, base)
-			if migrateErr != nil {
-				return Config{}, migrateErr
-			}
-			cfg = applyDefaults(cfg, base)
-			if err := save(path, cfg); err != nil {
-				return Config{}, err
-			}
-			return cfg, nil
-		}
-		cfg := defaults(base)
-		if err := save(path, cfg); err != nil {
-			return Config{}, err
-		}
-		return loadWithKoanf(path, base)
+// Load returns the effective config with the workspace layer: when
+// workspaceDir (a workspace's .maibot directory) holds a maibot.jsonc or
+// maibot.conf, its keys override the global file and are in turn overridden
+// by MAIBOT_ variables. Only a missing global file is written here. A
+// pending migration is applied in memory and reported in Migration; Migrate
+// writes it, unless a restore pinned the file at its version.
+func Load(workspaceDir string) (Loaded, error) {
+	base, err := resolveBaseDir()
+	if err != nil {
+		return Loaded{}, err
 	}
-
-	cfg, err := loadFromPath(path)
+	source, data, ok, err := migrationSource(base)
 	if err != nil {
-		return Config{}, err
+		return Loaded{}, err
+	}
+	if !ok {
+		path := filepath.Join(base, defaultConfigName)
+		if err := save(path, defaults(base)); err != nil {
+			return Loaded{}, err
+		}
+		return loadLayers(path, nil, workspaceDir, base)
+	}
+	if problems := CheckFile(source, data, false); len(problems) > 0 {
+		// Migrating would drop misspelled keys and the values the problems
+		// point at. Leave the file for the user to fix.
+		if filepath.Base(source) == legacyConfigName {
+			return Loaded{}, problems
+		}
+		return loadLayers(source, data, workspaceDir, base)
 	}
-	cfg, err = migrate(cfg, base)
+	migrated, m, err := planMigration(source, data, base)
 	if err != nil {
-		return Config{}, err
+		return Loaded{}, err
 	}
-	cfg = applyDefaults(cfg, base)
-	if err := save(path, cfg); err != nil {
-		return Config{}, err
+	loaded, err := loadLayers(m.Target, migrated, workspaceDir, base)
+	if m.pending() {
+		m.Pinned = pinnedVersion(base) == m.From
+		loaded.Migration = &m
 	}
-	return loadWithKoanf(path, base)
+	return loaded, err
 }
 
 func resolveBaseDir() (string, error) {
@@ -149,41 +179,6 @@ func resolveBaseDir() (string, error) {
 	return filepath.Join(home, ".maibot"), nil
 }
 
-func loadFromPath(path string) (Config, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return Config{}, err
-	}
-	var cfg Config
-	if err := json.Unmarshal(data, &cfg); err != nil {
-		return Config{}, err
-	}
-	return cfg, nil
-}
-
-func loadWithKoanf(path, base string) (Config, error) {
-	k := koanf.New(".")
-	if err := k.Load(file.Provider(path), koanfjson.Parser()); err != nil {
-		return Config{}, err
-	}
-	envPrefix := "MAIBOT_"
-	if err := k.Load(env.Provider(envPrefix, ".", func(key string) string {
-		n := strings.TrimPrefix(key, envPrefix)
-		n = strings.ToLower(n)
-		n = strings.ReplaceAll(n, "__", ".")
-		return n
-	}), nil); err != nil {
-		return Config{}, err
-	}
-
-	var cfg Config
-	if err := k.Unmarshal("", &cfg); err != nil {
-		return Config{}, err
-	}
-	cfg = applyDefaults(cfg, base)
-	return cfg, nil
-}
-
 func defaults(base string) Config {
 	return Config{
 		Version: schemaVersion,
@@ -194,6 +189,7 @@ func defaults(base string) Config {
 			DataHome:             base,
 			InstanceTickInterval: "15s",
 			LockTimeoutSeconds:   8,
+			ConfigBackups:        10,
 		},
 		Logging: Logging{
 			FilePath:       filepath.Join(base, "logs", "installer.log"),
@@ -231,6 +227,10 @@ func defaults(base string) Config {
 			InstallBackoffSec:   1,
 			PreferCatalogSource: false,
 		},
+		MaiBot: MaiBot{
+			RepoURL: "https://github.com/Mai-with-u/MaiBot.git",
+			Ref:     "main",
+		},
 	}
 }
 
@@ -257,6 +257,9 @@ func applyDefaults(cfg Config, base string) Config {
 	if cfg.Installer.LockTimeoutSeconds <= 0 {
 		cfg.Installer.LockTimeoutSeconds = d.Installer.LockTimeoutSeconds
 	}
+	if cfg.Installer.ConfigBackups <= 0 {
+		cfg.Installer.ConfigBackups = d.Installer.ConfigBackups
+	}
 	if strings.TrimSpace(cfg.Logging.FilePath) == "" {
 		cfg.Logging.FilePath = filepath.Join(cfg.Installer.DataHome, "logs", "installer.log")
 	}
@@ -297,11 +300,7 @@ func applyDefaults(cfg Config, base string) Config {
 	if len(shared) > 0 {
 		cfg.Git.Mirrors = mergeGitMirrors(shared, cfg.Git.Mirrors)
 	}
-	for i := range cfg.Git.Mirrors {
-		if strings.TrimSpace(cfg.Git.Mirrors[i].Name) == "" {
-			cfg.Git.Mirrors[i].Name = "mirror"
-		}
-	}
+	nameGitMirrors(cfg.Git.Mirrors)
 	if cfg.Modules.CatalogTimeoutSec <= 0 {
 		cfg.Modules.CatalogTimeoutSec = d.Modules.CatalogTimeoutSec
 	}
@@ -311,6 +310,12 @@ func applyDefaults(cfg Config, base string) Config {
 	if cfg.Modules.InstallBackoffSec < 0 {
 		cfg.Modules.InstallBackoffSec = d.Modules.InstallBackoffSec
 	}
+	if strings.TrimSpace(cfg.MaiBot.RepoURL) == "" {
+		cfg.MaiBot.RepoURL = d.MaiBot.RepoURL
+	}
+	if strings.TrimSpace(cfg.MaiBot.Ref) == "" {
+		cfg.MaiBot.Ref = d.MaiBot.Ref
+	}
 	return cfg
 }
 
@@ -328,8 +333,47 @@ func save(path string, cfg Config) error {
 	if err != nil {
 		return err
 	}
-	data = append(data, '\n')
-	return os.WriteFile(path, data, 0o644)
+	return writeAtomic(path, append(data, '\n'))
+}
+
+// writeAtomic replaces path with data. Several maibot processes may load the
+// config at once (workspace exec), so none of them may read it half written.
+func writeAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	// Keep the mode of the file being replaced; CreateTemp makes it 0600.
+	mode := os.FileMode(0o644)
+	if st, err := os.Stat(path); err == nil {
+		mode = st.Mode().Perm()
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// nameGitMirrors names the mirrors that have no name.
+func nameGitMirrors(mirrors []GitMirror) {
+	for i := range mirrors {
+		if strings.TrimSpace(mirrors[i].Name) == "" {
+			mirrors[i].Name = "mirror"
+		}
+	}
 }
 
 func mirrorURLsToGitMirrors(urls []string) []GitMirror {
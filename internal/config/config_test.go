@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -80,3 +81,332 @@ func TestApplyDefaultsAddsSharedDownloadMirrorsToGit(t *testing.T) {
 		t.Fatalf("first shared mirror=%q", out.Git.Mirrors[0].BaseURL)
 	}
 }
+
+func TestLoadLayersWorkspaceAndEnvOverrideGlobal(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("MAIBOT_INSTALLER__INSTANCE_TICK_INTERVAL", "5s")
+	if _, err := LoadOrCreate(); err != nil {
+		t.Fatalf("LoadOrCreate error: %v", err)
+	}
+
+	wsDir := filepath.Join(t.TempDir(), ".maibot")
+	if err := os.MkdirAll(wsDir, 0o755); err != nil {
+		t.Fatalf("mkdir error: %v", err)
+	}
+	overrides := []byte(`{"git": {"retry_per_source": 5}, "logging": {"max_size_mb": 3}}`)
+	if err := os.WriteFile(filepath.Join(wsDir, WorkspaceFileName), overrides, 0o644); err != nil {
+		t.Fatalf("write workspace config error: %v", err)
+	}
+
+	loaded, err := Load(wsDir)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if loaded.Config.Git.RetryPerSource != 5 || loaded.Config.Logging.MaxSizeMB != 3 {
+		t.Fatalf("workspace overrides not applied: %+v %+v", loaded.Config.Git, loaded.Config.Logging)
+	}
+	if loaded.Config.Installer.InstanceTickInterval != "5s" {
+		t.Fatalf("tick interval = %q, want env override", loaded.Config.Installer.InstanceTickInterval)
+	}
+	if !loaded.Config.Git.MirrorFirst {
+		t.Fatalf("git.mirror_first from the global file was lost")
+	}
+	want := map[string]string{
+		"git.retry_per_source":             LayerWorkspace,
+		"installer.instance_tick_interval": LayerEnv,
+		"installer.lock_timeout_seconds":   LayerGlobal,
+	}
+	for key, layer := range want {
+		if got := loaded.Origins[key].Layer; got != layer {
+			t.Fatalf("origin of %s = %q, want %q", key, got, layer)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(wsDir, WorkspaceFileName), []byte(`{"installer": {"data_home": "/x"}}`), 0o644); err != nil {
+		t.Fatalf("write workspace config error: %v", err)
+	}
+	loaded, err = Load(wsDir)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if len(loaded.Problems) != 1 || loaded.Problems[0].Path != "installer.data_home" {
+		t.Fatalf("problems = %v, want the workspace installer.data_home rejected", loaded.Problems)
+	}
+	if loaded.Config.Installer.DataHome == "/x" {
+		t.Fatalf("workspace override of installer.data_home was applied")
+	}
+}
+
+func TestDocumentSetIsTypeChecked(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	doc, err := OpenGlobal()
+	if err != nil {
+		t.Fatalf("OpenGlobal error: %v", err)
+	}
+	if err := doc.Set("git.retry_per_source", []string{"abc"}); err == nil {
+		t.Fatalf("expected a non-integer retry count to be rejected")
+	}
+	if err := doc.Set("git.retry_per_sources", []string{"3"}); err == nil {
+		t.Fatalf("expected an unknown key to be rejected")
+	}
+	if err := doc.Set("git.retry_per_source", []string{"4"}); err != nil {
+		t.Fatalf("Set error: %v", err)
+	}
+	if err := doc.Append("mirrors.urls", []string{"https://a.example"}); err != nil {
+		t.Fatalf("Append error: %v", err)
+	}
+	if err := doc.Remove("mirrors.urls", []string{"https://ghfast.top"}); err != nil {
+		t.Fatalf("Remove error: %v", err)
+	}
+	if err := doc.Append("git.mirrors", []string{`{"name": "own", "base_url": "https://git.example", "enabled": true}`}); err != nil {
+		t.Fatalf("Append object error: %v", err)
+	}
+	if err := doc.Remove("git.mirrors", []string{"fastgit"}); err != nil {
+		t.Fatalf("Remove by name error: %v", err)
+	}
+	if err := doc.Save(); err != nil {
+		t.Fatalf("Save error: %v", err)
+	}
+
+	cfg, err := LoadOrCreate()
+	if err != nil {
+		t.Fatalf("LoadOrCreate error: %v", err)
+	}
+	if cfg.Git.RetryPerSource != 4 {
+		t.Fatalf("retry_per_source = %d, want 4", cfg.Git.RetryPerSource)
+	}
+	urls := cfg.Mirrors.URLs
+	if urls[0] == "https://ghfast.top" || urls[len(urls)-1] != "https://a.example" {
+		t.Fatalf("mirrors.urls = %v", urls)
+	}
+	for _, m := range cfg.Git.Mirrors {
+		if m.Name == "fastgit" {
+			t.Fatalf("fastgit mirror was not removed: %v", cfg.Git.Mirrors)
+		}
+	}
+}
+
+func TestCheckFileReportsLinesAndSuggestions(t *testing.T) {
+	data := []byte(`{
+  "installer": {
+    "instance_tick_interval": "soon",
+    "language": "fr"
+  },
+  "git": {
+    "retry_per_sourse": 3,
+    "mirrors": [
+      {"name": "a", "base_url": "https://a.example", "enabled": true},
+      {"name": "b", "base_url": "ftp://b.example", "enabled": true}
+    ]
+  },
+  "modules": {"install_backoff_seconds": -1}
+}
+`)
+	problems := CheckFile("maibot.conf", data, false)
+	want := map[string]int{
+		"installer.instance_tick_interval": 3,
+		"installer.language":               4,
+		"git.retry_per_sourse":             7,
+		"git.mirrors[1].base_url":          10,
+		"modules.install_backoff_seconds":  13,
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("problems = %v, want %d", problems, len(want))
+	}
+	for _, p := range problems {
+		line, ok := want[p.Path]
+		if !ok || p.Line != line {
+			t.Fatalf("unexpected problem %s (want line %d)", p, line)
+		}
+		if p.Path == "git.retry_per_sourse" && !strings.Contains(p.Message, `"retry_per_source"`) {
+			t.Fatalf("no suggestion for misspelled key: %s", p)
+		}
+	}
+
+	if problems := CheckFile("maibot.conf", []byte("{\n  \"git\": {,\n}\n"), false); len(problems) != 1 || problems[0].Line != 2 {
+		t.Fatalf("syntax error problems = %v, want one on line 2", problems)
+	}
+}
+
+func TestMigrateFailureLeavesFileAndRestoreRollsBack(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	base := filepath.Join(home, ".maibot")
+	if err := os.MkdirAll(base, 0o755); err != nil {
+		t.Fatalf("mkdir error: %v", err)
+	}
+	path := filepath.Join(base, "maibot.conf")
+	original := []byte("{\n  \"version\": 2,\n  \"installer\": {\n    \"repo\": \"x/y\"\n  }\n}\n")
+	if err := os.WriteFile(path, original, 0o644); err != nil {
+		t.Fatalf("write config error: %v", err)
+	}
+
+	plan := migrationPlan
+	migrationPlan = []migrationStep{{from: 2, to: 3, run: func(Config, string) (Config, error) {
+		return Config{}, os.ErrInvalid
+	}}}
+	_, err := Migrate()
+	migrationPlan = plan
+	if err == nil {
+		t.Fatalf("Migrate with a failing step succeeded")
+	}
+	if data, _ := os.ReadFile(path); string(data) != string(original) {
+		t.Fatalf("failed migration rewrote the config:\n%s", data)
+	}
+	if backups, _ := ListBackups(); len(backups) != 0 {
+		t.Fatalf("failed migration left %d backups", len(backups))
+	}
+
+	preview, err := PreviewMigration()
+	if err != nil {
+		t.Fatalf("PreviewMigration error: %v", err)
+	}
+	if !strings.Contains(preview.Diff, "-  \"version\": 2,") || !strings.Contains(preview.Diff, "+  \"version\": 3,") {
+		t.Fatalf("preview diff misses the version change:\n%s", preview.Diff)
+	}
+	m, err := Migrate()
+	if err != nil || m.From != 2 || m.To != 3 || m.Backup == "" {
+		t.Fatalf("Migrate = %+v, %v", m, err)
+	}
+
+	restored, saved, err := RestoreBackup(filepath.Base(m.Backup))
+	if err != nil || saved == "" {
+		t.Fatalf("RestoreBackup = %+v, %q, %v", restored, saved, err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != string(original) {
+		t.Fatalf("restore did not bring back the original:\n%s", data)
+	}
+
+	var newest string
+	for i := 0; i < 4; i++ {
+		if newest, err = writeBackup(base, path, original); err != nil {
+			t.Fatalf("writeBackup error: %v", err)
+		}
+	}
+	if err := pruneBackups(base, 2, ""); err != nil {
+		t.Fatalf("pruneBackups error: %v", err)
+	}
+	backups, _ := ListBackups()
+	if len(backups) != 2 || backups[0].Path != newest {
+		t.Fatalf("backups after prune = %+v, want the newest 2", backups)
+	}
+}
+
+func TestLoadLeavesFileAloneAndSaveKeepsComments(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	base := filepath.Join(home, ".maibot")
+	if err := os.MkdirAll(base, 0o755); err != nil {
+		t.Fatalf("mkdir error: %v", err)
+	}
+	path := filepath.Join(base, "maibot.jsonc")
+	original := "// owned by ops\n{\n  \"version\": 3,\n  \"git\": {\n    \"retry_per_source\": 3, // flaky proxies\n  },\n}\n"
+	if err := os.WriteFile(path, []byte(original), 0o600); err != nil {
+		t.Fatalf("write config error: %v", err)
+	}
+
+	loaded, err := Load("")
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if loaded.GlobalPath != path || loaded.Config.Git.RetryPerSource != 3 {
+		t.Fatalf("Load = %s with retry_per_source %d, want %s with 3", loaded.GlobalPath, loaded.Config.Git.RetryPerSource, path)
+	}
+	if data, _ := os.ReadFile(path); string(data) != original {
+		t.Fatalf("Load rewrote the config:\n%s", data)
+	}
+
+	doc, err := OpenGlobal()
+	if err != nil {
+		t.Fatalf("OpenGlobal error: %v", err)
+	}
+	if err := doc.Set("git.retry_per_source", []string{"5"}); err != nil {
+		t.Fatalf("Set error: %v", err)
+	}
+	if err := doc.Set("maibot.ref", []string{"dev"}); err != nil {
+		t.Fatalf("Set error: %v", err)
+	}
+	if err := doc.Save(); err != nil {
+		t.Fatalf("Save error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read config error: %v", err)
+	}
+	for _, want := range []string{"// owned by ops", "\"retry_per_source\": 5, // flaky proxies", "\"ref\": \"dev\""} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("saved config misses %q:\n%s", want, data)
+		}
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat config error: %v", err)
+	}
+	if st.Mode().Perm() != 0o600 {
+		t.Fatalf("saved config mode = %v, want 0600", st.Mode().Perm())
+	}
+}
+
+func TestEnvOverridesAreTyped(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("MAIBOT_MIRRORS__URLS", "https://a.example, https://b.example")
+	t.Setenv("MAIBOT_MODULES__CATALOG_URLS", `["https://c.example/catalog.json"]`)
+	t.Setenv("MAIBOT_GIT__MIRROR_FIRST", "true")
+	// The effective list starts with the shared mirrors, then fastgit.
+	t.Setenv("MAIBOT_GIT__MIRRORS__2__ENABLED", "true")
+	t.Setenv("MAIBOT_GIT__MIRRORS__3", `{"name":"own","base_url":"https://git.example","enabled":true}`)
+	t.Setenv("MAIBOT_GIT__RETRY_PER_SOURCE", "many")
+	t.Setenv("MAIBOT_VERSION", "v0.3.0")
+
+	loaded, err := Load("")
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	cfg := loaded.Config
+	if got := strings.Join(cfg.Mirrors.URLs, " "); got != "https://a.example https://b.example" {
+		t.Fatalf("mirrors.urls = %q", got)
+	}
+	if len(cfg.Modules.CatalogURLs) != 1 || !cfg.Git.MirrorFirst {
+		t.Fatalf("catalog_urls = %v, mirror_first = %v", cfg.Modules.CatalogURLs, cfg.Git.MirrorFirst)
+	}
+	mirrors := map[string]GitMirror{}
+	for _, m := range cfg.Git.Mirrors {
+		mirrors[m.Name] = m
+	}
+	if !mirrors["fastgit"].Enabled || mirrors["own"].BaseURL != "https://git.example" {
+		t.Fatalf("git.mirrors = %+v", cfg.Git.Mirrors)
+	}
+	if o := loaded.Origins["git.mirrors"]; o.Layer != LayerEnv || o.Source != "MAIBOT_GIT__MIRRORS__2__ENABLED, MAIBOT_GIT__MIRRORS__3" {
+		t.Fatalf("git.mirrors origin = %+v", o)
+	}
+	if cfg.Version != schemaVersion {
+		t.Fatalf("MAIBOT_VERSION changed the schema version to %d", cfg.Version)
+	}
+	if len(loaded.Problems) != 1 || loaded.Problems[0].Source != "MAIBOT_GIT__RETRY_PER_SOURCE" {
+		t.Fatalf("problems = %v, want one for MAIBOT_GIT__RETRY_PER_SOURCE", loaded.Problems)
+	}
+}
+
+func TestEnvIndexesCountTheEffectiveList(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("MAIBOT_MIRRORS__URLS", "https://a.example, https://b.example")
+	t.Setenv("MAIBOT_GIT__MIRRORS__0__ENABLED", "false")
+	// Appended field by field, but without the base_url a mirror needs.
+	t.Setenv("MAIBOT_GIT__MIRRORS__3__NAME", "half")
+
+	loaded, err := Load("")
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	mirrors := loaded.Config.Git.Mirrors
+	if len(mirrors) != 3 || mirrors[0].BaseURL != "https://a.example" || mirrors[0].Enabled || !mirrors[1].Enabled {
+		t.Fatalf("git.mirrors = %+v, want the first shared mirror disabled", mirrors)
+	}
+	if o := loaded.Origins["git.mirrors"]; o.Layer != LayerEnv {
+		t.Fatalf("git.mirrors origin = %+v", o)
+	}
+	if len(loaded.Problems) != 1 || loaded.Problems[0].Path != "git.mirrors[3]" || !strings.Contains(loaded.Problems[0].Message, "base_url") {
+		t.Fatalf("problems = %v, want one for the incomplete git.mirrors[3]", loaded.Problems)
+	}
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+const diffContext = 3
+
+// unifiedDiff returns a unified diff from a to b, line by line, with three
+// lines of context around each change. It is empty when they are equal.
+func unifiedDiff(aName, bName string, a, b []byte) string {
+	x, y := splitLines(string(a)), splitLines(string(b))
+	ops := diffLines(x, y)
+	if len(ops) == 0 {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+	for start := 0; start < len(ops); {
+		// Skip to the next change and open a hunk with context before it.
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		lo := max(first-diffContext, start)
+		// Extend the hunk while changes are at most two contexts apart.
+		hi, equal := first, 0
+		for i := first; i < len(ops); i++ {
+			if ops[i].kind != ' ' {
+				hi, equal = i+1, 0
+				continue
+			}
+			if equal++; equal > 2*diffContext {
+				break
+			}
+		}
+		hi = min(hi+diffContext, len(ops))
+
+		aStart, bStart, aLen, bLen := ops[lo].a, ops[lo].b, 0, 0
+		for _, op := range ops[lo:hi] {
+			if op.kind != '+' {
+				aLen++
+			}
+			if op.kind != '-' {
+				bLen++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aStart, aLen), hunkRange(bStart, bLen))
+		for _, op := range ops[lo:hi] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			out.WriteByte('\n')
+		}
+		start = hi
+	}
+	return out.String()
+}
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+	// a and b are the 0-based line numbers in each input before this op.
+	a, b int
+}
+
+// diffLines returns the edit script from x to y based on their longest
+// common subsequence, or nil when they are equal. Config files are small,
+// so the quadratic table is fine.
+func diffLines(x, y []string) []diffOp {
+	n, m := len(x), len(y)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	changed := false
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && x[i] == y[j]:
+			ops = append(ops, diffOp{kind: ' ', line: x[i], a: i, b: j})
+			i++
+			j++
+		case i < n && (j == m || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{kind: '-', line: x[i], a: i, b: j})
+			i++
+			changed = true
+		default:
+			ops = append(ops, diffOp{kind: '+', line: y[j], a: i, b: j})
+			j++
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return ops
+}
+
+func splitLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// hunkRange formats a hunk's start and length the way diff -u does: an
+// empty range names the line before it.
+func hunkRange(start, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if n == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
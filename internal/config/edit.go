@@ -0,0 +1,434 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	koanfjson "github.com/knadh/koanf/parsers/json"
+	"github.com/knadh/koanf/v2"
+)
+
+// Document is one config file opened for editing: the global config or a
+// workspace's. Keys are the dotted koanf paths. Saving rewrites only the
+// values that changed, so comments in a .jsonc file and the order of keys
+// are kept.
+type Document struct {
+	Path      string
+	base      string
+	workspace bool
+	// text is the file's contents to patch; onDisk is what the file holds,
+	// nil while it does not exist.
+	text, onDisk []byte
+	k            *koanf.Koanf
+	// inherited is what the file is layered over: the defaults, and for a
+	// workspace document the global file too. Lists the file does not set
+	// yet are appended to from there.
+	inherited *koanf.Koanf
+}
+
+// OpenGlobal opens the global config, creating maibot.conf first if needed.
+func OpenGlobal() (*Document, error) {
+	loaded, err := Load("")
+	if err != nil {
+		return nil, err
+	}
+	if loaded.Migration != nil {
+		return nil, fmt.Errorf("%s needs to be migrated first; run maibot config migrate", loaded.Migration.Source)
+	}
+	base := filepath.Dir(loaded.GlobalPath)
+	doc, err := openDocument(loaded.GlobalPath, base)
+	if err != nil {
+		return nil, err
+	}
+	if doc.inherited, err = defaultsKoanf(base); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// OpenWorkspace opens the config in workspaceDir, the workspace's .maibot
+// directory. A missing file is an empty maibot.conf.
+func OpenWorkspace(workspaceDir string) (*Document, error) {
+	global, err := OpenGlobal()
+	if err != nil {
+		return nil, err
+	}
+	if sameFile(workspaceDir, global.base) {
+		return nil, fmt.Errorf("%s holds the global config, not a workspace config", workspaceDir)
+	}
+	path := findConfigFile(workspaceDir)
+	if path == "" {
+		path = filepath.Join(workspaceDir, WorkspaceFileName)
+	}
+	doc, err := openDocument(path, global.base)
+	if err != nil {
+		return nil, err
+	}
+	doc.workspace = true
+	doc.inherited = global.inherited
+	if err := doc.inherited.Merge(global.k); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func openDocument(path, base string) (*Document, error) {
+	doc := &Document{Path: path, base: base, k: koanf.New(".")}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return doc, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if doc.k, err = parseKoanf(path, data); err != nil {
+		return nil, err
+	}
+	doc.text, doc.onDisk = data, data
+	return doc, nil
+}
+
+func defaultsKoanf(base string) (*koanf.Koanf, error) {
+	data, err := json.Marshal(defaults(base))
+	if err != nil {
+		return nil, err
+	}
+	return parseKoanf("", data)
+}
+
+func readKoanf(path string) (*koanf.Koanf, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseKoanf(path, data)
+}
+
+func parseKoanf(path string, data []byte) (*koanf.Koanf, error) {
+	raw, err := koanfjson.Parser().Unmarshal(normalizeJSON(path, data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	k := koanf.New(".")
+	for key, v := range raw {
+		if err := k.Set(key, v); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Set replaces the value at key with values parsed as the key's type. Lists
+// take one value per element or a single JSON array; list elements that are
+// objects, such as git.mirrors entries, are given as JSON.
+func (d *Document) Set(key string, values []string) error {
+	t, err := d.settable(key)
+	if err != nil {
+		return err
+	}
+	v, err := parseValue(key, t, values)
+	if err != nil {
+		return err
+	}
+	return d.k.Set(key, v)
+}
+
+// Append adds values to the end of the list at key.
+func (d *Document) Append(key string, values []string) error {
+	t, err := d.settable(key)
+	if err != nil {
+		return err
+	}
+	if t.Kind() != reflect.Slice {
+		return fmt.Errorf("%s is not a list", key)
+	}
+	current, err := d.list(key)
+	if err != nil {
+		return err
+	}
+	added, err := parseValue(key, t, values)
+	if err != nil {
+		return err
+	}
+	return d.k.Set(key, append(current, added.([]any)...))
+}
+
+// Remove deletes the elements equal to values from the list at key. An
+// object element also matches its "name".
+func (d *Document) Remove(key string, values []string) error {
+	t, err := d.settable(key)
+	if err != nil {
+		return err
+	}
+	if t.Kind() != reflect.Slice {
+		return fmt.Errorf("%s is not a list", key)
+	}
+	current, err := d.list(key)
+	if err != nil {
+		return err
+	}
+	kept := make([]any, 0, len(current))
+	found := map[string]bool{}
+	for _, item := range current {
+		match := ""
+		for _, v := range values {
+			if elementMatches(item, v) {
+				match = v
+				break
+			}
+		}
+		if match == "" {
+			kept = append(kept, item)
+			continue
+		}
+		found[match] = true
+	}
+	var missing []string
+	for _, v := range values {
+		if !found[v] {
+			missing = append(missing, v)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s has no element %s", key, strings.Join(missing, ", "))
+	}
+	return d.k.Set(key, kept)
+}
+
+// Unset drops key from the file, so the value of the layer below, the
+// global file or the default, applies again.
+func (d *Document) Unset(key string) error {
+	if _, err := FieldType(key); err != nil {
+		return err
+	}
+	if !d.k.Exists(key) {
+		return fmt.Errorf("%s is not set in %s", key, d.Path)
+	}
+	d.k.Delete(key)
+	return nil
+}
+
+// Save validates the effective config the document would produce and then
+// writes the file atomically. Nothing is written when validation fails or
+// the file would not change.
+func (d *Document) Save() error {
+	cfg, err := d.effective()
+	if err != nil {
+		return err
+	}
+	if err := Validate(cfg); err != nil {
+		return err
+	}
+	data := []byte(encodeJSON(d.k.Raw(), reflect.TypeOf(Config{}), "") + "\n")
+	if d.text != nil {
+		if patched, err := patchJSON(d.Path, d.text, d.k.Raw()); err == nil {
+			data = patched
+		}
+	}
+	if d.onDisk != nil && bytes.Equal(data, d.onDisk) {
+		return nil
+	}
+	if err := writeAtomic(d.Path, data); err != nil {
+		return err
+	}
+	d.text, d.onDisk = data, data
+	return nil
+}
+
+// Replace swaps the document's contents for data, as after an external
+// edit, checking the new contents first.
+func (d *Document) Replace(data []byte) error {
+	if problems := CheckFile(d.Path, data, d.workspace); len(problems) > 0 {
+		return problems
+	}
+	k, err := parseKoanf(d.Path, data)
+	if err != nil {
+		return err
+	}
+	d.k, d.text = k, data
+	return nil
+}
+
+func (d *Document) effective() (Config, error) {
+	k := koanf.New(".")
+	if d.workspace {
+		for _, key := range d.k.Keys() {
+			if isGlobalOnly(key) {
+				return Config{}, fmt.Errorf("%s can only be set in the global config", key)
+			}
+		}
+	}
+	if err := k.Merge(d.inherited); err != nil {
+		return Config{}, err
+	}
+	if err := k.Merge(d.k); err != nil {
+		return Config{}, err
+	}
+	var cfg Config
+	if err := k.UnmarshalWithConf("", &cfg, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+		return Config{}, fmt.Errorf("%s: %w", d.Path, err)
+	}
+	return applyDefaults(cfg, d.base), nil
+}
+
+func (d *Document) settable(key string) (reflect.Type, error) {
+	t, err := FieldType(key)
+	if err != nil {
+		return nil, err
+	}
+	if t.Kind() == reflect.Struct {
+		return nil, fmt.Errorf("%s is a section; set one of its keys instead", key)
+	}
+	if d.workspace && isGlobalOnly(key) {
+		return nil, fmt.Errorf("%s can only be set in the global config", key)
+	}
+	return t, nil
+}
+
+// list returns the current elements at key, falling back to the layers
+// below when the file does not set the list yet.
+func (d *Document) list(key string) ([]any, error) {
+	v := d.k.Get(key)
+	if v == nil {
+		v = d.inherited.Get(key)
+	}
+	if v == nil {
+		return nil, nil
+	}
+	items, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s in %s is not a list", key, d.Path)
+	}
+	return append([]any(nil), items...), nil
+}
+
+// FieldType returns the Go type of the Config field at a dotted key, matching
+// the json tags.
+func FieldType(key string) (reflect.Type, error) {
+	t := reflect.TypeOf(Config{})
+	for _, part := range strings.Split(key, ".") {
+		if t.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("unknown config key %q", key)
+		}
+		field, ok := fieldByTag(t, part)
+		if !ok {
+			return nil, fmt.Errorf("unknown config key %q", key)
+		}
+		t = field.Type
+	}
+	return t, nil
+}
+
+func fieldByTag(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if jsonName(f) == name {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// parseValue converts command-line values to the JSON-shaped value koanf
+// holds for a field of type t.
+func parseValue(key string, t reflect.Type, values []string) (any, error) {
+	if t.Kind() == reflect.Slice {
+		if len(values) == 1 && strings.HasPrefix(strings.TrimSpace(values[0]), "[") {
+			ptr := reflect.New(t)
+			if err := json.Unmarshal([]byte(values[0]), ptr.Interface()); err != nil {
+				return nil, fmt.Errorf("%s expects a JSON array: %w", key, err)
+			}
+			return toGeneric(ptr.Elem().Interface())
+		}
+		out := make([]any, 0, len(values))
+		for _, raw := range values {
+			v, err := parseScalar(key, t.Elem(), raw)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	}
+	if len(values) != 1 {
+		return nil, fmt.Errorf("%s expects exactly one value, got %d", key, len(values))
+	}
+	return parseScalar(key, t, values[0])
+}
+
+func parseScalar(key string, t reflect.Type, raw string) (any, error) {
+	switch t.Kind() {
+	case reflect.String:
+		return raw, nil
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%s expects an integer, got %q", key, raw)
+		}
+		return n, nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%s expects true or false, got %q", key, raw)
+		}
+		return b, nil
+	case reflect.Struct:
+		ptr := reflect.New(t)
+		dec := json.NewDecoder(strings.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(ptr.Interface()); err != nil {
+			return nil, fmt.Errorf("%s expects a JSON object: %w", key, err)
+		}
+		return toGeneric(ptr.Elem().Interface())
+	default:
+		return nil, fmt.Errorf("%s has unsupported type %s", key, t)
+	}
+}
+
+// toGeneric round-trips v through JSON so it has the same shape as values
+// parsed from the file.
+func toGeneric(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out any
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func elementMatches(item any, raw string) bool {
+	if m, ok := item.(map[string]any); ok {
+		if name, _ := m["name"].(string); name == raw {
+			return true
+		}
+		var want any
+		if json.Unmarshal([]byte(raw), &want) == nil {
+			return reflect.DeepEqual(item, want)
+		}
+		return false
+	}
+	switch v := item.(type) {
+	case string:
+		return v == raw
+	default:
+		return fmt.Sprint(v) == strings.TrimSpace(raw)
+	}
+}
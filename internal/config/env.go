@@ -0,0 +1,285 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/knadh/koanf/v2"
+)
+
+// envOverride is one MAIBOT_ variable that names a config key. A variable
+// that picks a list element, such as MAIBOT_GIT__MIRRORS__0__BASE_URL, has
+// key "git.mirrors", index 0 and field "base_url".
+type envOverride struct {
+	name  string
+	value string
+	key   string
+	index int
+	field string
+	// t is the type the value is parsed as: the key's, the element's or the
+	// element field's.
+	t reflect.Type
+}
+
+func (o envOverride) path() string {
+	if o.index < 0 {
+		return o.key
+	}
+	return joinPath(fmt.Sprintf("%s[%d]", o.key, o.index), o.field)
+}
+
+// envLayer is the env layer built by loadEnv. indexed holds the list keys
+// some variable changed by element; their value is the whole effective list,
+// so they are applied again after the defaults that merge lists.
+type envLayer struct {
+	k       *koanf.Koanf
+	vars    map[string]string
+	indexed map[string]bool
+}
+
+// loadEnv builds the env layer from MAIBOT_ variables. Parts of a key are
+// joined by "__": MAIBOT_GIT__RETRY_PER_SOURCE sets git.retry_per_source.
+// Values are parsed as the key's type. A list takes a JSON array or
+// comma-separated values, and a numeric part picks one element of it, as in
+// MAIBOT_GIT__MIRRORS__0__BASE_URL; indexes count the effective list, as
+// `config get` shows it, and the index right after its end appends. below is
+// the merge of the lower layers and the defaults, base the config directory.
+//
+// Variables whose first part is no config section, such as MAIBOT_HOME, are
+// not config and are skipped. So is MAIBOT_VERSION: the installer scripts
+// use it for the release to install, and the schema version belongs to the
+// file anyway.
+func loadEnv(environ []string, below *koanf.Koanf, base string) (envLayer, Problems) {
+	env := envLayer{k: koanf.New("."), vars: map[string]string{}, indexed: map[string]bool{}}
+	k := env.k
+	var problems Problems
+	add := func(source, path, msg string) {
+		problems = append(problems, Problem{Source: source, Path: path, Message: msg})
+	}
+
+	var overrides []envOverride
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, envPrefix) {
+			continue
+		}
+		o, config, msg := parseEnvName(name)
+		if !config {
+			continue
+		}
+		o.value = value
+		if msg != "" {
+			add(name, o.path(), msg)
+			continue
+		}
+		overrides = append(overrides, o)
+	}
+	// Whole values first, so that the lists elements index into reflect
+	// them, then elements by index, so that appends can build on each other
+	// whatever order the environment lists them in.
+	sort.SliceStable(overrides, func(i, j int) bool {
+		a, b := overrides[i], overrides[j]
+		if (a.index < 0) != (b.index < 0) {
+			return a.index < 0
+		}
+		if a.key != b.key {
+			return a.key < b.key
+		}
+		if a.index != b.index {
+			return a.index < b.index
+		}
+		return a.name < b.name
+	})
+
+	var effective map[string]any
+	lists := map[string][]any{}
+	// appended records, per list, the variables that created each element
+	// past the end of the effective list.
+	appended := map[string]map[int][]string{}
+	for _, o := range overrides {
+		v, err := parseEnvValue(o)
+		if err != nil {
+			add(o.name, o.path(), strings.TrimPrefix(err.Error(), o.path()+" "))
+			continue
+		}
+		failed := false
+		checkNode(o.path(), v, o.t, func(path, msg string) {
+			add(o.name, path, msg)
+			failed = true
+		})
+		if failed {
+			continue
+		}
+		if o.index < 0 {
+			if err := k.Set(o.key, v); err != nil {
+				add(o.name, o.path(), err.Error())
+				continue
+			}
+			env.vars[o.key] = o.name
+			continue
+		}
+
+		items, ok := lists[o.key]
+		if !ok {
+			if effective == nil {
+				if effective, err = effectiveValues(base, below, k); err != nil {
+					add(o.name, o.path(), err.Error())
+					continue
+				}
+			}
+			items, _ = effective[o.key].([]any)
+			items = append([]any(nil), items...)
+			appended[o.key] = map[int][]string{}
+		}
+		if o.index > len(items) {
+			add(o.name, o.path(), fmt.Sprintf("index %d is past the end of %s, which has %d elements", o.index, o.key, len(items)))
+			continue
+		}
+		if o.index == len(items) {
+			items = append(items, nil)
+			appended[o.key][o.index] = nil
+		}
+		if _, ok := appended[o.key][o.index]; ok {
+			appended[o.key][o.index] = append(appended[o.key][o.index], o.name)
+		}
+		if o.field == "" {
+			items[o.index] = v
+		} else {
+			elem, _ := items[o.index].(map[string]any)
+			copied := make(map[string]any, len(elem)+1)
+			for key, value := range elem {
+				copied[key] = value
+			}
+			copied[o.field] = v
+			items[o.index] = copied
+		}
+		lists[o.key] = items
+		if env.vars[o.key] == "" {
+			env.vars[o.key] = o.name
+		} else {
+			env.vars[o.key] += ", " + o.name
+		}
+	}
+
+	for key, items := range lists {
+		// An element appended field by field must still be complete; leave
+		// it out rather than have it dropped silently later on.
+		kept := items[:0:0]
+		for i, item := range items {
+			names, ok := appended[key][i]
+			if ok {
+				path := fmt.Sprintf("%s[%d]", key, i)
+				if missing := missingFields(key, item); len(missing) > 0 {
+					add(strings.Join(names, ", "), path, "is missing "+strings.Join(missing, ", "))
+					continue
+				}
+			}
+			kept = append(kept, item)
+		}
+		if err := k.Set(key, kept); err != nil {
+			add(env.vars[key], key, err.Error())
+			continue
+		}
+		env.indexed[key] = true
+	}
+	return env, problems
+}
+
+// effectiveValues returns the config the merge of layers produces once the
+// defaults are applied, as flattenConfig does.
+func effectiveValues(base string, layers ...*koanf.Koanf) (map[string]any, error) {
+	k := koanf.New(".")
+	for _, l := range layers {
+		if err := k.Merge(l); err != nil {
+			return nil, err
+		}
+	}
+	var cfg Config
+	// Values of the wrong type are reported elsewhere.
+	_ = k.UnmarshalWithConf("", &cfg, koanf.UnmarshalConf{Tag: "json"})
+	return flattenConfig(applyDefaults(cfg, base))
+}
+
+// missingFields returns the required fields of a list element of key that
+// item leaves unset or empty.
+func missingFields(key string, item any) []string {
+	elem, _ := item.(map[string]any)
+	var missing []string
+	for _, field := range requiredFields[key+"[]"] {
+		if s, ok := elem[field].(string); !ok || strings.TrimSpace(s) == "" {
+			missing = append(missing, field)
+		}
+	}
+	return missing
+}
+
+// parseEnvName maps a variable name to the value it overrides. config is
+// false for MAIBOT_ variables that are not config; msg is set for ones that
+// look like config but name no key.
+func parseEnvName(name string) (o envOverride, config bool, msg string) {
+	o = envOverride{name: name, index: -1}
+	parts := strings.Split(strings.ToLower(strings.TrimPrefix(name, envPrefix)), "__")
+	t := reflect.TypeOf(Config{})
+	if _, ok := fieldByTag(t, parts[0]); !ok || parts[0] == "version" {
+		return o, false, ""
+	}
+	for _, part := range parts {
+		switch {
+		case t.Kind() == reflect.Slice && o.index < 0:
+			n, err := strconv.Atoi(part)
+			if err != nil || n < 0 {
+				return o, true, fmt.Sprintf("%s is a list; expected an element index, got %q", o.key, part)
+			}
+			o.index, t = n, t.Elem()
+		case t.Kind() == reflect.Struct:
+			if o.index < 0 {
+				o.key = joinPath(o.key, part)
+			} else {
+				o.field = joinPath(o.field, part)
+			}
+			f, ok := fieldByTag(t, part)
+			if !ok {
+				return o, true, unknownKeyMessage(t, part)
+			}
+			t = f.Type
+		default:
+			return o, true, fmt.Sprintf("%s has no key %q", o.path(), part)
+		}
+	}
+	if t.Kind() == reflect.Struct && o.index < 0 {
+		return o, true, "is a section; set one of its keys instead"
+	}
+	o.t = t
+	return o, true, ""
+}
+
+// parseEnvValue parses a variable's value as its type, in the shape values
+// read from a file have.
+func parseEnvValue(o envOverride) (any, error) {
+	path := o.path()
+	if o.t.Kind() != reflect.Slice {
+		v, err := parseScalar(path, o.t, o.value)
+		if err != nil {
+			return nil, err
+		}
+		return toGeneric(v)
+	}
+	var values []string
+	if strings.HasPrefix(strings.TrimSpace(o.value), "[") {
+		values = []string{o.value}
+	} else {
+		for _, item := range strings.Split(o.value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+	}
+	v, err := parseValue(path, o.t, values)
+	if err != nil {
+		return nil, err
+	}
+	return toGeneric(v)
+}
@@ -0,0 +1,460 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// A config file named *.jsonc may hold // and /* */ comments and trailing
+// commas, so the reason for a setting can sit next to it. Every other file
+// is plain JSON.
+const jsoncExt = ".jsonc"
+
+// configFileNames are the names a config file is looked for under, in
+// order, both in ~/.maibot and in a workspace's .maibot directory.
+var configFileNames = []string{"maibot" + jsoncExt, WorkspaceFileName}
+
+func isJSONC(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), jsoncExt)
+}
+
+// findConfigFile returns the config file in dir, or "" when there is none.
+func findConfigFile(dir string) string {
+	for _, name := range configFileNames {
+		path := filepath.Join(dir, name)
+		if st, err := os.Stat(path); err == nil && !st.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// normalizeJSON returns the contents of the file at path as plain JSON.
+// Comments and trailing commas of a .jsonc file are blanked out byte for
+// byte, so offsets and line numbers still point into the file.
+func normalizeJSON(path string, data []byte) []byte {
+	if !isJSONC(path) {
+		return data
+	}
+	return blankComments(data, true)
+}
+
+// blankComments replaces comments, and trailing commas if asked, with
+// spaces. Newlines stay.
+func blankComments(data []byte, trailingCommas bool) []byte {
+	out := append([]byte(nil), data...)
+	comma := -1 // the last comma, until something other than space follows
+	for i := 0; i < len(out); i++ {
+		switch c := out[i]; {
+		case c == '"':
+			i = stringEnd(out, i)
+			comma = -1
+		case c == '/' && i+1 < len(out) && out[i+1] == '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		case c == '/' && i+1 < len(out) && out[i+1] == '*':
+			end := len(out)
+			if n := bytes.Index(out[i+2:], []byte("*/")); n >= 0 {
+				end = i + 2 + n + 2
+			}
+			for ; i < end; i++ {
+				if out[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+			i--
+		case c == ',':
+			comma = i
+		case c == '}' || c == ']':
+			if comma >= 0 && trailingCommas {
+				out[comma] = ' '
+			}
+			comma = -1
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+		default:
+			comma = -1
+		}
+	}
+	return out
+}
+
+// stringEnd returns the offset of the quote closing the string at start.
+func stringEnd(data []byte, start int) int {
+	for i := start + 1; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return len(data) - 1
+}
+
+// jsonObject and jsonMember locate an object's members in a file, so single
+// values can be rewritten without touching anything around them.
+type jsonObject struct {
+	open, close int
+	members     []jsonMember
+}
+
+type jsonMember struct {
+	key                  string
+	keyStart             int
+	valueStart, valueEnd int
+	object               *jsonObject
+}
+
+var errNotObject = errors.New("not a JSON object")
+
+type jsonScanner struct {
+	data []byte
+	pos  int
+}
+
+func (s *jsonScanner) skipSpace() {
+	for s.pos < len(s.data) && strings.IndexByte(" \t\r\n", s.data[s.pos]) >= 0 {
+		s.pos++
+	}
+}
+
+func (s *jsonScanner) peek() byte {
+	if s.pos >= len(s.data) {
+		return 0
+	}
+	return s.data[s.pos]
+}
+
+// value skips the value at pos and returns its layout if it is an object.
+func (s *jsonScanner) value() (*jsonObject, error) {
+	s.skipSpace()
+	switch s.peek() {
+	case 0:
+		return nil, errNotObject
+	case '{':
+		return s.object()
+	case '[':
+		s.pos++
+		for {
+			s.skipSpace()
+			if s.peek() == ']' {
+				s.pos++
+				return nil, nil
+			}
+			if _, err := s.value(); err != nil {
+				return nil, err
+			}
+			s.skipSpace()
+			if s.peek() == ',' {
+				s.pos++
+			}
+		}
+	case '"':
+		s.pos = stringEnd(s.data, s.pos) + 1
+	default:
+		for s.pos < len(s.data) && strings.IndexByte(",}] \t\r\n", s.data[s.pos]) < 0 {
+			s.pos++
+		}
+	}
+	return nil, nil
+}
+
+func (s *jsonScanner) object() (*jsonObject, error) {
+	obj := &jsonObject{open: s.pos}
+	s.pos++
+	for {
+		s.skipSpace()
+		switch s.peek() {
+		case '}':
+			obj.close = s.pos
+			s.pos++
+			return obj, nil
+		case '"':
+		default:
+			return nil, errNotObject
+		}
+		m := jsonMember{keyStart: s.pos}
+		end := stringEnd(s.data, s.pos)
+		if err := json.Unmarshal(s.data[s.pos:end+1], &m.key); err != nil {
+			return nil, err
+		}
+		s.pos = end + 1
+		s.skipSpace()
+		if s.peek() != ':' {
+			return nil, errNotObject
+		}
+		s.pos++
+		s.skipSpace()
+		m.valueStart = s.pos
+		child, err := s.value()
+		if err != nil {
+			return nil, err
+		}
+		m.valueEnd, m.object = s.pos, child
+		obj.members = append(obj.members, m)
+		s.skipSpace()
+		if s.peek() == ',' {
+			s.pos++
+		}
+	}
+}
+
+type textEdit struct {
+	start, end int
+	text       string
+}
+
+// patchJSON rewrites the file so it holds want, touching only the values
+// that differ: comments, key order and formatting of everything else stay.
+// It fails with errNotObject when data is not a JSON object.
+func patchJSON(path string, data []byte, want map[string]any) ([]byte, error) {
+	norm := normalizeJSON(path, data)
+	// Checked first: the scanner below trusts the syntax.
+	var have map[string]any
+	if err := json.Unmarshal(norm, &have); err != nil {
+		return nil, errNotObject
+	}
+	// The scanner sees trailing commas, so new members can follow them.
+	text := data
+	if isJSONC(path) {
+		text = blankComments(data, false)
+	}
+	root, err := (&jsonScanner{data: text}).value()
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, errNotObject
+	}
+	p := &patcher{data: text}
+	p.object(root, have, want, reflect.TypeOf(Config{}))
+
+	// Apply back to front; at the same offset a removal goes before an
+	// insertion so it cannot eat the inserted text.
+	sort.SliceStable(p.edits, func(i, j int) bool {
+		if p.edits[i].start != p.edits[j].start {
+			return p.edits[i].start > p.edits[j].start
+		}
+		return p.edits[i].end > p.edits[j].end
+	})
+	out := append([]byte(nil), data...)
+	for _, e := range p.edits {
+		out = append(out[:e.start], append([]byte(e.text), out[e.end:]...)...)
+	}
+	return out, nil
+}
+
+type patcher struct {
+	data  []byte
+	edits []textEdit
+}
+
+func (p *patcher) object(obj *jsonObject, have, want map[string]any, t reflect.Type) {
+	var last *jsonMember // the last member that stays
+	removedLast := false
+	for i := range obj.members {
+		m := &obj.members[i]
+		w, ok := want[m.key]
+		if !ok {
+			p.remove(m)
+			removedLast = true
+			continue
+		}
+		last, removedLast = m, false
+		h := have[m.key]
+		if sameJSON(h, w) {
+			continue
+		}
+		child := childType(t, m.key)
+		hm, hok := h.(map[string]any)
+		wm, wok := w.(map[string]any)
+		if hok && wok && m.object != nil {
+			p.object(m.object, hm, wm, child)
+			continue
+		}
+		p.edits = append(p.edits, textEdit{m.valueStart, m.valueEnd, encodeJSON(w, child, p.indent(m.keyStart))})
+	}
+	var added []string
+	for _, key := range orderedKeys(want, t) {
+		if _, ok := have[key]; !ok {
+			added = append(added, key)
+		}
+	}
+	if len(added) == 0 {
+		if removedLast && last != nil {
+			// The comma after the new last member now separates nothing.
+			if i := p.skip(last.valueEnd, " \t\r\n"); i < len(p.data) && p.data[i] == ',' {
+				p.edits = append(p.edits, textEdit{i, i + 1, ""})
+			}
+		}
+		return
+	}
+	indent := p.indent(obj.open) + "  "
+	if len(obj.members) > 0 {
+		indent = p.indent(obj.members[0].keyStart)
+	}
+	entries := make([]string, len(added))
+	for i, key := range added {
+		entries[i] = encodeJSON(key, nil, "") + ": " + encodeJSON(want[key], childType(t, key), indent)
+	}
+	body := strings.Join(entries, ",\n"+indent)
+	switch {
+	case last != nil:
+		// New members go on their own lines below the last one, after its
+		// comma and any comment on its line.
+		comma := p.skip(last.valueEnd, " \t")
+		hasComma := comma < len(p.data) && p.data[comma] == ','
+		eol := comma
+		if hasComma {
+			eol = p.skip(comma+1, " \t\r")
+		}
+		if eol >= len(p.data) || p.data[eol] != '\n' {
+			if hasComma && removedLast {
+				p.edits = append(p.edits, textEdit{comma, comma + 1, ""})
+			}
+			p.edits = append(p.edits, textEdit{last.valueEnd, last.valueEnd, ",\n" + indent + body})
+			return
+		}
+		text := "\n" + indent + body
+		switch {
+		case !hasComma && eol == last.valueEnd:
+			text = "," + text
+		case !hasComma:
+			p.edits = append(p.edits, textEdit{last.valueEnd, last.valueEnd, ","})
+		case !removedLast && last == &obj.members[len(obj.members)-1]:
+			text += "," // keep the file's trailing comma
+		}
+		p.edits = append(p.edits, textEdit{eol, eol, text})
+	case len(obj.members) > 0:
+		p.edits = append(p.edits, textEdit{obj.open + 1, obj.open + 1, "\n" + indent + body})
+	default:
+		p.edits = append(p.edits, textEdit{obj.open + 1, obj.close, "\n" + indent + body + "\n" + p.indent(obj.open)})
+	}
+}
+
+// remove deletes a member with its comma, and its whole line when nothing
+// else is on it. Comments on that line go with it.
+func (p *patcher) remove(m *jsonMember) {
+	start, end := m.keyStart, p.skip(m.valueEnd, " \t")
+	if end < len(p.data) && p.data[end] == ',' {
+		end = p.skip(end+1, " \t\r")
+	}
+	lineStart := bytes.LastIndexByte(p.data[:start], '\n') + 1
+	if end < len(p.data) && p.data[end] == '\n' && strings.TrimSpace(string(p.data[lineStart:start])) == "" {
+		start, end = lineStart, end+1
+	}
+	p.edits = append(p.edits, textEdit{start, end, ""})
+}
+
+func (p *patcher) skip(i int, chars string) int {
+	for i < len(p.data) && strings.IndexByte(chars, p.data[i]) >= 0 {
+		i++
+	}
+	return i
+}
+
+// indent returns the leading whitespace of the line holding offset.
+func (p *patcher) indent(offset int) string {
+	lineStart := bytes.LastIndexByte(p.data[:offset], '\n') + 1
+	end := p.skip(lineStart, " \t")
+	return string(p.data[lineStart:end])
+}
+
+func sameJSON(a, b any) bool {
+	x, errA := json.Marshal(a)
+	y, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(x, y)
+}
+
+// childType is the type of key within t, or nil when t does not know it.
+func childType(t reflect.Type, key string) reflect.Type {
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	if f, ok := fieldByTag(t, key); ok {
+		return f.Type
+	}
+	return nil
+}
+
+// orderedKeys returns the keys of m in the field order of t, the order
+// maibot writes them in, followed by any unknown keys sorted.
+func orderedKeys(m map[string]any, t reflect.Type) []string {
+	keys := sortedKeys(m)
+	if t == nil || t.Kind() != reflect.Struct {
+		return keys
+	}
+	index := func(key string) int {
+		for i := 0; i < t.NumField(); i++ {
+			if jsonName(t.Field(i)) == key {
+				return i
+			}
+		}
+		return t.NumField()
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return index(keys[i]) < index(keys[j]) })
+	return keys
+}
+
+// encodeJSON formats v with two-space indentation after indent, with object
+// keys in the field order of t.
+func encodeJSON(v any, t reflect.Type, indent string) string {
+	var b strings.Builder
+	writeJSON(&b, v, t, indent)
+	return b.String()
+}
+
+func writeJSON(b *strings.Builder, v any, t reflect.Type, indent string) {
+	switch x := v.(type) {
+	case map[string]any:
+		if len(x) == 0 {
+			b.WriteString("{}")
+			return
+		}
+		b.WriteString("{\n")
+		for i, key := range orderedKeys(x, t) {
+			if i > 0 {
+				b.WriteString(",\n")
+			}
+			b.WriteString(indent + "  ")
+			writeJSON(b, key, nil, "")
+			b.WriteString(": ")
+			writeJSON(b, x[key], childType(t, key), indent+"  ")
+		}
+		b.WriteString("\n" + indent + "}")
+	case []any:
+		if len(x) == 0 {
+			b.WriteString("[]")
+			return
+		}
+		var elem reflect.Type
+		if t != nil && t.Kind() == reflect.Slice {
+			elem = t.Elem()
+		}
+		b.WriteString("[\n")
+		for i, item := range x {
+			if i > 0 {
+				b.WriteString(",\n")
+			}
+			b.WriteString(indent + "  ")
+			writeJSON(b, item, elem, indent+"  ")
+		}
+		b.WriteString("\n" + indent + "]")
+	default:
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(v); err != nil {
+			b.WriteString("null")
+			return
+		}
+		b.Write(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+	}
+}
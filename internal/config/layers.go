@@ -0,0 +1,297 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/knadh/koanf/maps"
+	koanfjson "github.com/knadh/koanf/parsers/json"
+	"github.com/knadh/koanf/v2"
+)
+
+// WorkspaceFileName is the optional per-workspace config kept in a
+// workspace's .maibot directory. It holds only the keys it overrides; a
+// maibot.jsonc next to it takes precedence.
+const WorkspaceFileName = "maibot.conf"
+
+const envPrefix = "MAIBOT_"
+
+// Layers in load order; a later layer overrides an earlier one key by key.
+// Lists are replaced as a whole, never merged.
+const (
+	LayerDefault   = "default"
+	LayerGlobal    = "global"
+	LayerWorkspace = "workspace"
+	LayerEnv       = "env"
+)
+
+// globalOnlyKeys describe the installer itself or shared state, so a
+// workspace file may not override them.
+var globalOnlyKeys = []string{
+	"version",
+	"installer.repo",
+	"installer.release_channel",
+	"installer.data_home",
+	"updater",
+}
+
+// Origin tells which layer an effective value came from. Source is the file
+// path for file layers and the variable name for the env layer.
+type Origin struct {
+	Layer  string `json:"layer" yaml:"layer"`
+	Source string `json:"source,omitempty" yaml:"source,omitempty"`
+}
+
+// Loaded is the effective config together with the files it was read from
+// and the origin of every value, keyed by dotted koanf path.
+type Loaded struct {
+	Config        Config
+	GlobalPath    string
+	WorkspacePath string
+	Origins       map[string]Origin
+	// Problems lists every invalid setting. Invalid values still load as
+	// far as they can, so commands that inspect or fix the config keep
+	// working; lifecycle commands refuse to run.
+	Problems Problems
+	// Migration is set while the global file still needs Migrate; Config
+	// already reflects the migrated values.
+	Migration *Migration
+}
+
+// Keys returns the dotted paths of all effective values, sorted.
+func (l Loaded) Keys() []string {
+	keys := make([]string, 0, len(l.Origins))
+	for key := range l.Origins {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type layer struct {
+	name   string
+	source string
+	k      *koanf.Koanf
+	// vars maps keys of the env layer to the variables that set them.
+	vars map[string]string
+}
+
+func (l layer) origin(key string) Origin {
+	if l.vars != nil {
+		return Origin{Layer: l.name, Source: l.vars[key]}
+	}
+	return Origin{Layer: l.name, Source: l.source}
+}
+
+// loadLayers reads the global file, the workspace file when workspaceDir
+// holds one, and MAIBOT_ variables, and merges them in that order. Only
+// unparsable files are an error; everything else ends up in Problems.
+func loadLayers(globalPath string, globalData []byte, workspaceDir, base string) (Loaded, error) {
+	var problems Problems
+	global, fileProblems, err := loadFileLayer(globalPath, globalData, false)
+	if err != nil {
+		return Loaded{}, err
+	}
+	problems = append(problems, fileProblems...)
+	layers := []layer{{name: LayerGlobal, source: globalPath, k: global}}
+
+	workspacePath := workspaceFilePath(globalPath, workspaceDir)
+	if workspacePath != "" {
+		ws, fileProblems, err := loadFileLayer(workspacePath, nil, true)
+		if err != nil {
+			return Loaded{}, err
+		}
+		problems = append(problems, fileProblems...)
+		// Reported above; never let a workspace move shared state.
+		for _, key := range ws.Keys() {
+			if isGlobalOnly(key) {
+				ws.Delete(key)
+			}
+		}
+		layers = append(layers, layer{name: LayerWorkspace, source: workspacePath, k: ws})
+	}
+
+	// List elements set by index are changed in the effective list the lower
+	// layers produce, defaults included.
+	below, err := defaultsKoanf(base)
+	if err != nil {
+		return Loaded{}, err
+	}
+	for _, l := range layers {
+		if err := below.Merge(l.k); err != nil {
+			return Loaded{}, err
+		}
+	}
+	env, envProblems := loadEnv(os.Environ(), below, base)
+	problems = append(problems, envProblems...)
+	layers = append(layers, layer{name: LayerEnv, k: env.k, vars: env.vars})
+
+	k := koanf.New(".")
+	for _, l := range layers {
+		if err := k.Merge(l.k); err != nil {
+			return Loaded{}, err
+		}
+	}
+	var cfg Config
+	// The struct tags are json ones; koanf looks for "koanf" tags by default
+	// and would silently drop every multi-word key. Values of the wrong type
+	// are already in problems and are left at their defaults.
+	if err := k.UnmarshalWithConf("", &cfg, koanf.UnmarshalConf{Tag: "json"}); err != nil && len(problems) == 0 {
+		return Loaded{}, err
+	}
+	cfg = applyDefaults(cfg, base)
+	// Lists changed by element already hold the effective list; merging
+	// the defaults in again would undo changes to merged-in elements.
+	if cfg, err = overlayIndexed(cfg, env); err != nil {
+		return Loaded{}, err
+	}
+
+	var invalid Problems
+	if err := Validate(cfg); errors.As(err, &invalid) {
+		// The effective config repeats what the layers set, and shared
+		// mirrors reappear in git.mirrors; report each value once.
+		reported := map[string]bool{}
+		for _, p := range problems {
+			reported[p.Path] = true
+			reported[p.Message] = true
+		}
+		for _, p := range invalid {
+			if !reported[p.Path] && !reported[p.Message] {
+				problems = append(problems, p)
+			}
+		}
+	} else if err != nil {
+		return Loaded{}, err
+	}
+
+	origins, err := resolveOrigins(cfg, layers)
+	if err != nil {
+		return Loaded{}, err
+	}
+	return Loaded{Config: cfg, GlobalPath: globalPath, WorkspacePath: workspacePath, Origins: origins, Problems: problems}, nil
+}
+
+// loadFileLayer checks and parses one config file, reading it unless data
+// is given. A file that does not parse is returned as the error, with the
+// line of the syntax error.
+func loadFileLayer(path string, data []byte, workspace bool) (*koanf.Koanf, Problems, error) {
+	if data == nil {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, nil, err
+		}
+	}
+	problems := CheckFile(path, data, workspace)
+	k, err := parseKoanf(path, data)
+	if err != nil {
+		if len(problems) > 0 {
+			return nil, nil, problems
+		}
+		return nil, nil, err
+	}
+	return k, problems, nil
+}
+
+// workspaceFilePath returns the workspace config below workspaceDir, or ""
+// when there is none. A workspace directory that is the global config
+// directory itself, as with a workspace created in the home directory, has
+// no separate layer.
+func workspaceFilePath(globalPath, workspaceDir string) string {
+	if strings.TrimSpace(workspaceDir) == "" || sameFile(workspaceDir, filepath.Dir(globalPath)) {
+		return ""
+	}
+	return findConfigFile(workspaceDir)
+}
+
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	if absA == absB {
+		return true
+	}
+	stA, errA := os.Stat(absA)
+	stB, errB := os.Stat(absB)
+	return errA == nil && errB == nil && os.SameFile(stA, stB)
+}
+
+func isGlobalOnly(key string) bool {
+	for _, g := range globalOnlyKeys {
+		if key == g || strings.HasPrefix(key, g+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveOrigins attributes every leaf of the effective config to the last
+// layer that set it. Values no layer set were filled in by the defaults.
+func resolveOrigins(cfg Config, layers []layer) (map[string]Origin, error) {
+	flat, err := flattenConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	origins := make(map[string]Origin, len(flat))
+	for key := range flat {
+		origins[key] = Origin{Layer: LayerDefault}
+		for i := len(layers) - 1; i >= 0; i-- {
+			if layers[i].k.Exists(key) {
+				origins[key] = layers[i].origin(key)
+				break
+			}
+		}
+	}
+	return origins, nil
+}
+
+// flattenConfig returns cfg as a map from dotted koanf paths to values. Lists
+// are leaves.
+func flattenConfig(cfg Config) (map[string]any, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := koanfjson.Parser().Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	flat, _ := maps.Flatten(raw, nil, ".")
+	return flat, nil
+}
+
+// Values returns the effective config as a map from dotted paths to values.
+func (l Loaded) Values() (map[string]any, error) {
+	return flattenConfig(l.Config)
+}
+
+// overlayIndexed sets the lists env changed by element in cfg.
+func overlayIndexed(cfg Config, env envLayer) (Config, error) {
+	if len(env.indexed) == 0 {
+		return cfg, nil
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return cfg, err
+	}
+	k, err := parseKoanf("", data)
+	if err != nil {
+		return cfg, err
+	}
+	for key := range env.indexed {
+		if err := k.Set(key, env.k.Get(key)); err != nil {
+			return cfg, err
+		}
+	}
+	var out Config
+	if err := k.UnmarshalWithConf("", &out, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+		return cfg, err
+	}
+	nameGitMirrors(out.Git.Mirrors)
+	return out, nil
+}
@@ -2,12 +2,25 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	backupPrefix = "config.backup."
+	backupLayout = "20060102-150405"
+	// pinName marks a global config restored at an older schema version. It
+	// holds that version; while the file is still at it the migration is not
+	// run automatically, only by an explicit Migrate.
+	pinName = "config.pinned"
+)
+
 type migrationStep struct {
 	from int
 	to   int
@@ -19,35 +32,178 @@ var migrationPlan = []migrationStep{
 	{from: 2, to: 3, run: migrateV2ToV3},
 }
 
-func migrate(cfg Config, base string) (Config, error) {
+// Migration describes a pending or finished migration of the global config.
+// Source is the file read, which is the legacy config.json before the first
+// migration to maibot.conf.
+type Migration struct {
+	Source string `json:"source" yaml:"source"`
+	Target string `json:"target" yaml:"target"`
+	From   int    `json:"from" yaml:"from"`
+	To     int    `json:"to" yaml:"to"`
+	// Backup is the copy of the original file; empty for a dry run.
+	Backup string `json:"backup,omitempty" yaml:"backup,omitempty"`
+	// Pinned is set by Load when a restore chose to keep the file at From.
+	Pinned bool `json:"pinned,omitempty" yaml:"pinned,omitempty"`
+}
+
+// MigrationPreview is what Migrate would write, as a unified diff.
+type MigrationPreview struct {
+	Migration
+	Diff string `json:"diff" yaml:"diff"`
+}
+
+// Backup is a copy of the global config taken before a migration or restore.
+type Backup struct {
+	Name      string    `json:"name" yaml:"name"`
+	Path      string    `json:"path" yaml:"path"`
+	CreatedAt time.Time `json:"created_at" yaml:"created_at"`
+	Version   int       `json:"version" yaml:"version"`
+	Bytes     int64     `json:"bytes" yaml:"bytes"`
+	// seq orders backups taken within the same second.
+	seq int
+}
+
+// migrationSource finds the global config file and reads it. ok is false
+// when there is neither a maibot.jsonc, a maibot.conf nor a legacy
+// config.json yet.
+func migrationSource(base string) (source string, data []byte, ok bool, err error) {
+	for _, name := range append(configFileNames, legacyConfigName) {
+		source = filepath.Join(base, name)
+		data, err = os.ReadFile(source)
+		if err == nil {
+			return source, data, true, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", nil, false, err
+		}
+	}
+	return "", nil, false, nil
+}
+
+// planMigration runs every pending step in memory and returns the file as
+// Migrate would write it: data with only the values the steps changed
+// rewritten, so comments and layout survive. Nothing is written here, so a
+// failing step leaves the file as it was.
+func planMigration(source string, data []byte, base string) ([]byte, Migration, error) {
+	target := source
+	if filepath.Base(source) == legacyConfigName {
+		target = filepath.Join(base, defaultConfigName)
+	}
+	var before Config
+	if err := json.Unmarshal(normalizeJSON(source, data), &before); err != nil {
+		return nil, Migration{}, fmt.Errorf("%s: %w", source, err)
+	}
+	cfg := before
 	if cfg.Version == 0 {
 		cfg.Version = 1
 	}
+	m := Migration{Source: source, Target: target, From: cfg.Version, To: cfg.Version}
 	if cfg.Version > schemaVersion {
-		return Config{}, fmt.Errorf("config version %d is newer than supported %d", cfg.Version, schemaVersion)
+		return nil, m, fmt.Errorf("config version %d is newer than supported %d", cfg.Version, schemaVersion)
 	}
-	if cfg.Version == schemaVersion {
-		return cfg, nil
-	}
-
-	backupPath := filepath.Join(base, "config.backup."+time.Now().UTC().Format("20060102-150405")+".json")
-	if err := backupConfig(cfg, backupPath); err != nil {
-		return Config{}, err
-	}
-
 	for cfg.Version < schemaVersion {
 		step, ok := findStep(cfg.Version)
 		if !ok {
-			return Config{}, fmt.Errorf("missing migration step for version %d", cfg.Version)
+			return nil, m, fmt.Errorf("missing migration step for version %d", cfg.Version)
 		}
 		next, err := step.run(cfg, base)
 		if err != nil {
-			return Config{}, err
+			return nil, m, fmt.Errorf("migrate config from version %d to %d: %w", step.from, step.to, err)
 		}
 		cfg = next
 		cfg.Version = step.to
 	}
-	return cfg, nil
+	m.To = cfg.Version
+	if m.From == m.To {
+		return data, m, nil
+	}
+
+	oldValues, err := flattenConfig(before)
+	if err != nil {
+		return nil, m, err
+	}
+	newValues, err := flattenConfig(cfg)
+	if err != nil {
+		return nil, m, err
+	}
+	k, err := parseKoanf(source, data)
+	if err != nil {
+		return nil, m, err
+	}
+	for key, v := range newValues {
+		if !sameJSON(oldValues[key], v) {
+			if err := k.Set(key, v); err != nil {
+				return nil, m, err
+			}
+		}
+	}
+	migrated, err := patchJSON(source, data, k.Raw())
+	if err != nil {
+		return nil, m, fmt.Errorf("%s: %w", source, err)
+	}
+	return migrated, m, nil
+}
+
+// pending reports whether Migrate has anything to do.
+func (m Migration) pending() bool {
+	return m.From != m.To || m.Source != m.Target
+}
+
+// Migrate brings the global config to the current schema version. The
+// original file is copied to a config.backup.<time>.json first, and old
+// backups beyond installer.config_backups are deleted. A config with
+// problems is not migrated: rewriting it would drop what they point at.
+func Migrate() (Migration, error) {
+	base, err := resolveBaseDir()
+	if err != nil {
+		return Migration{}, err
+	}
+	source, data, ok, err := migrationSource(base)
+	if err != nil || !ok {
+		return Migration{}, err
+	}
+	if problems := CheckFile(source, data, false); len(problems) > 0 {
+		return Migration{}, problems
+	}
+	migrated, m, err := planMigration(source, data, base)
+	if err != nil || !m.pending() {
+		return m, err
+	}
+	if m.Backup, err = writeBackup(base, source, data); err != nil {
+		return m, err
+	}
+	if err := writeAtomic(m.Target, migrated); err != nil {
+		return m, err
+	}
+	if err := removePin(base); err != nil {
+		return m, err
+	}
+	return m, pruneBackups(base, backupRetention(m.Target, migrated), "")
+}
+
+// PreviewMigration returns what Migrate would do without writing anything.
+func PreviewMigration() (MigrationPreview, error) {
+	base, err := resolveBaseDir()
+	if err != nil {
+		return MigrationPreview{}, err
+	}
+	source, data, ok, err := migrationSource(base)
+	if err != nil {
+		return MigrationPreview{}, err
+	}
+	if !ok {
+		return MigrationPreview{}, fmt.Errorf("no config file in %s", base)
+	}
+	if problems := CheckFile(source, data, false); len(problems) > 0 {
+		return MigrationPreview{}, problems
+	}
+	migrated, m, err := planMigration(source, data, base)
+	preview := MigrationPreview{Migration: m}
+	if err != nil || !m.pending() {
+		return preview, err
+	}
+	preview.Diff = unifiedDiff(source, m.Target, data, migrated)
+	return preview, nil
 }
 
 func findStep(v int) (migrationStep, bool) {
@@ -73,14 +229,207 @@ func migrateV2ToV3(cfg Config, _ string) (Config, error) {
 	return cfg, nil
 }
 
-func backupConfig(cfg Config, path string) error {
-	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+// writeBackup stores data, the contents of source, unchanged as a new
+// backup. A .jsonc source keeps its extension so its comments still parse.
+func writeBackup(base, source string, data []byte) (string, error) {
+	if err := os.MkdirAll(base, 0o755); err != nil {
+		return "", err
+	}
+	ext := ".json"
+	if isJSONC(source) {
+		ext = jsoncExt
+	}
+	stamp := time.Now().UTC().Format(backupLayout)
+	path := filepath.Join(base, backupPrefix+stamp+ext)
+	for i := 2; ; i++ {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if errors.Is(err, os.ErrExist) {
+			path = filepath.Join(base, fmt.Sprintf("%s%s-%d%s", backupPrefix, stamp, i, ext))
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		_, err = f.Write(data)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			_ = os.Remove(path)
+			return "", err
+		}
+		return path, nil
+	}
+}
+
+// ListBackups returns the config backups, newest first.
+func ListBackups() ([]Backup, error) {
+	base, err := resolveBaseDir()
+	if err != nil {
+		return nil, err
+	}
+	return listBackups(base)
+}
+
+func listBackups(base string) ([]Backup, error) {
+	paths, err := filepath.Glob(filepath.Join(base, backupPrefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+	backups := make([]Backup, 0, len(paths))
+	for _, path := range paths {
+		name := filepath.Base(path)
+		ext := filepath.Ext(name)
+		if ext != ".json" && ext != jsoncExt {
+			continue
+		}
+		b := Backup{Name: name, Path: path}
+		// A second backup within the same second gets a "-N" suffix.
+		if stamp := strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), ext); len(stamp) >= len(backupLayout) {
+			if t, err := time.Parse(backupLayout, stamp[:len(backupLayout)]); err == nil {
+				b.CreatedAt = t
+			}
+			b.seq, _ = strconv.Atoi(strings.TrimPrefix(stamp[len(backupLayout):], "-"))
+		}
+		if st, err := os.Stat(path); err == nil {
+			b.Bytes = st.Size()
+			if b.CreatedAt.IsZero() {
+				b.CreatedAt = st.ModTime().UTC()
+			}
+		}
+		if data, err := os.ReadFile(path); err == nil {
+			var v struct {
+				Version int `json:"version"`
+			}
+			if json.Unmarshal(normalizeJSON(path, data), &v) == nil {
+				b.Version = v.Version
+			}
+		}
+		backups = append(backups, b)
+	}
+	sort.SliceStable(backups, func(i, j int) bool {
+		if !backups[i].CreatedAt.Equal(backups[j].CreatedAt) {
+			return backups[i].CreatedAt.After(backups[j].CreatedAt)
+		}
+		return backups[i].seq > backups[j].seq
+	})
+	return backups, nil
+}
+
+// pruneBackups deletes all but the newest keep backups, never touching
+// except. keep <= 0 means the default.
+func pruneBackups(base string, keep int, except string) error {
+	if keep <= 0 {
+		keep = defaults(base).Installer.ConfigBackups
+	}
+	backups, err := listBackups(base)
+	if err != nil {
 		return err
 	}
-	data, err := json.MarshalIndent(cfg, "", "  ")
+	kept := 0
+	for _, b := range backups {
+		if b.Path == except {
+			continue
+		}
+		if kept++; kept <= keep {
+			continue
+		}
+		if err := os.Remove(b.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreBackup makes the named backup the global config again. The
+// current file is backed up first, so a restore can itself be undone. A
+// backup from an older schema version is pinned at it, so that the next
+// command does not migrate it right back; Migrate moves it on.
+func RestoreBackup(name string) (restored Backup, saved string, err error) {
+	base, err := resolveBaseDir()
+	if err != nil {
+		return Backup{}, "", err
+	}
+	backups, err := listBackups(base)
+	if err != nil {
+		return Backup{}, "", err
+	}
+	for _, b := range backups {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(b.Name, backupPrefix), filepath.Ext(b.Name))
+		if b.Name == name || b.Path == name || stamp == name {
+			restored = b
+			break
+		}
+	}
+	if restored.Path == "" {
+		return Backup{}, "", fmt.Errorf("no config backup %q in %s", name, base)
+	}
+	data, err := os.ReadFile(restored.Path)
 	if err != nil {
+		return Backup{}, "", err
+	}
+	if problems := CheckFile(restored.Path, data, false); len(problems) > 0 {
+		return Backup{}, "", problems
+	}
+	// A backup with comments can only go back to a maibot.jsonc.
+	target := filepath.Join(base, defaultConfigName)
+	if isJSONC(restored.Path) {
+		target = filepath.Join(base, configFileNames[0])
+	}
+	current := findConfigFile(base)
+	if current != "" {
+		data, err := os.ReadFile(current)
+		if err != nil {
+			return Backup{}, "", err
+		}
+		if saved, err = writeBackup(base, current, data); err != nil {
+			return Backup{}, "", err
+		}
+	}
+	if err := writeAtomic(target, data); err != nil {
+		return Backup{}, "", err
+	}
+	if current != "" && current != target {
+		if err := os.Remove(current); err != nil {
+			return Backup{}, "", err
+		}
+	}
+	if _, m, err := planMigration(target, data, base); err == nil && m.pending() {
+		err = writePin(base, m.From)
+	} else {
+		err = removePin(base)
+	}
+	if err != nil {
+		return Backup{}, "", err
+	}
+	return restored, saved, pruneBackups(base, backupRetention(target, data), restored.Path)
+}
+
+// backupRetention reads installer.config_backups from a config file.
+func backupRetention(path string, data []byte) int {
+	var cfg Config
+	_ = json.Unmarshal(normalizeJSON(path, data), &cfg)
+	return cfg.Installer.ConfigBackups
+}
+
+// pinnedVersion returns the schema version a restore pinned the global
+// config at, or 0.
+func pinnedVersion(base string) int {
+	data, err := os.ReadFile(filepath.Join(base, pinName))
+	if err != nil {
+		return 0
+	}
+	v, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return v
+}
+
+func writePin(base string, version int) error {
+	return writeAtomic(filepath.Join(base, pinName), []byte(strconv.Itoa(version)+"\n"))
+}
+
+func removePin(base string) error {
+	if err := os.Remove(filepath.Join(base, pinName)); err != nil && !errors.Is(err, os.ErrNotExist) {
 		return err
 	}
-	data = append(data, '\n')
-	return os.WriteFile(path, data, 0o644)
+	return nil
 }
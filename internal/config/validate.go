@@ -0,0 +1,489 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Problem is one invalid setting. Path is the dotted key with list indexes,
+// such as git.mirrors[1].base_url. File and Line point at the setting when it
+// came from a file; Source names the variable when it came from the
+// environment.
+type Problem struct {
+	File    string `json:"file,omitempty" yaml:"file,omitempty"`
+	Line    int    `json:"line,omitempty" yaml:"line,omitempty"`
+	Source  string `json:"source,omitempty" yaml:"source,omitempty"`
+	Path    string `json:"path" yaml:"path"`
+	Message string `json:"message" yaml:"message"`
+}
+
+func (p Problem) String() string {
+	var where string
+	switch {
+	case p.File != "" && p.Line > 0:
+		where = fmt.Sprintf("%s:%d: ", p.File, p.Line)
+	case p.File != "":
+		where = p.File + ": "
+	case p.Source != "":
+		where = p.Source + ": "
+	}
+	if p.Path == "" {
+		return where + p.Message
+	}
+	return where + p.Path + ": " + p.Message
+}
+
+// Problems is every problem found in a config; it is an error when not empty.
+type Problems []Problem
+
+func (p Problems) Error() string {
+	lines := make([]string, len(p))
+	for i, problem := range p {
+		lines[i] = problem.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Validate checks the effective config. Zero values are fine: they stand for
+// the defaults.
+func Validate(cfg Config) error {
+	var problems Problems
+	if strings.TrimSpace(cfg.Installer.Repo) == "" {
+		problems = append(problems, Problem{Path: "installer.repo", Message: "is empty"})
+	}
+	if strings.TrimSpace(cfg.Installer.ReleaseChannel) == "" {
+		problems = append(problems, Problem{Path: "installer.release_channel", Message: "is empty"})
+	}
+	if strings.TrimSpace(cfg.Installer.DataHome) == "" {
+		problems = append(problems, Problem{Path: "installer.data_home", Message: "is empty"})
+	}
+	if strings.TrimSpace(cfg.Installer.InstanceTickInterval) == "" {
+		problems = append(problems, Problem{Path: "installer.instance_tick_interval", Message: "is empty"})
+	}
+	if cfg.Updater.RequireSignature && strings.TrimSpace(cfg.Updater.MiniSignPublicKey) == "" {
+		problems = append(problems, Problem{Path: "updater.minisign_public_key", Message: "is empty while updater.require_signature is true"})
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	walkValues("", raw, func(path string, v any) {
+		if msg := checkValue(path, v); msg != "" {
+			problems = append(problems, Problem{Path: path, Message: msg})
+		}
+	})
+	if len(problems) == 0 {
+		return nil
+	}
+	return problems
+}
+
+// CheckFile validates the contents of a config file on their own: syntax,
+// unknown keys, value types and values. A .jsonc file may hold comments. Keys reserved for the global file are
+// rejected in a workspace file.
+func CheckFile(path string, data []byte, workspace bool) Problems {
+	data = normalizeJSON(path, data)
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		p := Problem{File: path, Message: err.Error()}
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			p.Line = lineAt(data, syntaxErr.Offset)
+			if off := int(syntaxErr.Offset) - 1; off >= 0 && off < len(data) && data[off] == '/' && !isJSONC(path) {
+				p.Message += "; comments need a .jsonc file, such as maibot.jsonc"
+			}
+		}
+		return Problems{p}
+	}
+	lines := keyLines(data)
+	var problems Problems
+	add := func(p, msg string) {
+		problems = append(problems, Problem{File: path, Line: lines[p], Path: p, Message: msg})
+	}
+	checkNode("", raw, reflect.TypeOf(Config{}), add)
+	if workspace {
+		if m, ok := raw.(map[string]any); ok {
+			flat := map[string]any{}
+			walkValues("", m, func(p string, v any) { flat[p] = v })
+			for _, p := range sortedKeys(flat) {
+				if isGlobalOnly(stripIndexes(p)) {
+					add(p, "can only be set in the global config")
+				}
+			}
+		}
+	}
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Line < problems[j].Line })
+	return problems
+}
+
+// checkNode compares a parsed JSON value with the Go type it decodes into and
+// checks the values of leaves.
+func checkNode(path string, v any, t reflect.Type, add func(path, msg string)) {
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := v.(map[string]any)
+		if !ok {
+			add(path, "expects an object, got "+jsonKind(v))
+			return
+		}
+		for _, key := range sortedKeys(m) {
+			p := joinPath(path, key)
+			field, ok := fieldByTag(t, key)
+			if !ok {
+				add(p, unknownKeyMessage(t, key))
+				continue
+			}
+			checkNode(p, m[key], field.Type, add)
+		}
+	case reflect.Slice:
+		items, ok := v.([]any)
+		if !ok {
+			if v != nil {
+				add(path, "expects a list, got "+jsonKind(v))
+			}
+			return
+		}
+		for i, item := range items {
+			checkNode(fmt.Sprintf("%s[%d]", path, i), item, t.Elem(), add)
+		}
+	case reflect.String:
+		if _, ok := v.(string); !ok {
+			add(path, "expects a string, got "+jsonKind(v))
+			return
+		}
+		if msg := checkValue(path, v); msg != "" {
+			add(path, msg)
+		}
+	case reflect.Int, reflect.Int64:
+		n, ok := v.(float64)
+		if !ok || n != math.Trunc(n) {
+			add(path, "expects an integer, got "+jsonKind(v))
+			return
+		}
+		if msg := checkValue(path, v); msg != "" {
+			add(path, msg)
+		}
+	case reflect.Bool:
+		if _, ok := v.(bool); !ok {
+			add(path, "expects true or false, got "+jsonKind(v))
+		}
+	}
+}
+
+// requiredFields lists, by list key with [] standing for the element, the
+// fields a list element is useless without.
+var requiredFields = map[string][]string{
+	"git.mirrors[]": {"base_url"},
+}
+
+type valueRule func(v any) string
+
+// valueRules check single values by key, with [] standing for any list
+// index. Empty strings and zero numbers mean "use the default" and pass.
+var valueRules = map[string]valueRule{
+	"version":                          versionRule,
+	"installer.repo":                   repoRule,
+	"installer.language":               languageRule,
+	"installer.instance_tick_interval": durationRule,
+	"installer.lock_timeout_seconds":   nonNegativeRule,
+	"installer.config_backups":         nonNegativeRule,
+	"logging.max_size_mb":              nonNegativeRule,
+	"logging.retention_days":           nonNegativeRule,
+	"logging.max_backup_files":         nonNegativeRule,
+	"mirrors.urls[]":                   httpURLRule,
+	"mirrors.probe_url":                httpURLRule,
+	"mirrors.probe_seconds":            nonNegativeRule,
+	"git.mirrors[].base_url":           httpURLRule,
+	"git.retry_per_source":             nonNegativeRule,
+	"git.retry_backoff_seconds":        nonNegativeRule,
+	"git.command_timeout_seconds":      nonNegativeRule,
+	"modules.catalog_urls[]":           httpURLRule,
+	"modules.catalog_timeout_seconds":  nonNegativeRule,
+	"modules.install_retries":          nonNegativeRule,
+	"modules.install_backoff_seconds":  nonNegativeRule,
+	"maibot.repo_url":                  repoURLRule,
+}
+
+func checkValue(path string, v any) string {
+	rule, ok := valueRules[stripIndexes(path)]
+	if !ok {
+		return ""
+	}
+	return rule(v)
+}
+
+func versionRule(v any) string {
+	if n, ok := number(v); ok && (n < 0 || n > schemaVersion) {
+		return fmt.Sprintf("version %d is not supported (newest is %d)", n, schemaVersion)
+	}
+	return ""
+}
+
+var repoPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+/[A-Za-z0-9_.-]+$`)
+
+func repoRule(v any) string {
+	if s, _ := v.(string); s != "" && !repoPattern.MatchString(strings.TrimSpace(s)) {
+		return fmt.Sprintf("%q is not an owner/name GitHub repository", s)
+	}
+	return ""
+}
+
+func languageRule(v any) string {
+	s, _ := v.(string)
+	lang := strings.ToLower(strings.TrimSpace(s))
+	if lang == "" || lang == "auto" || strings.HasPrefix(lang, "en") || strings.HasPrefix(lang, "zh") {
+		return ""
+	}
+	return fmt.Sprintf("unknown language %q (use auto, en or zh-CN)", s)
+}
+
+func durationRule(v any) string {
+	s, _ := v.(string)
+	if strings.TrimSpace(s) == "" {
+		return ""
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Sprintf("%q is not a duration such as 15s or 1m", s)
+	}
+	if d <= 0 {
+		return fmt.Sprintf("%q must be positive", s)
+	}
+	return ""
+}
+
+func nonNegativeRule(v any) string {
+	if n, ok := number(v); ok && n < 0 {
+		return fmt.Sprintf("must not be negative, got %d", n)
+	}
+	return ""
+}
+
+func httpURLRule(v any) string {
+	s, _ := v.(string)
+	if strings.TrimSpace(s) == "" {
+		return ""
+	}
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Sprintf("%q is not an http(s) URL", s)
+	}
+	return ""
+}
+
+// repoURLRule accepts anything git can clone: URLs, scp-like addresses and
+// local paths. It only catches values that are plainly not one.
+func repoURLRule(v any) string {
+	s, _ := v.(string)
+	if strings.ContainsAny(strings.TrimSpace(s), " \t\n") {
+		return fmt.Sprintf("%q is not a git repository URL", s)
+	}
+	return ""
+}
+
+func number(v any) (int, bool) {
+	switch n := v.(type) {
+	case float64:
+		return int(n), true
+	case int:
+		return n, true
+	}
+	return 0, false
+}
+
+// unknownKeyMessage suggests the closest key of the same section, or the
+// section a misplaced key belongs to.
+func unknownKeyMessage(t reflect.Type, key string) string {
+	best, bestDist := "", len(key)/3+2
+	for i := 0; i < t.NumField(); i++ {
+		name := jsonName(t.Field(i))
+		if d := levenshtein(strings.ToLower(key), name); d < bestDist {
+			best, bestDist = name, d
+		}
+	}
+	if best == "" {
+		for _, full := range settableKeys() {
+			if strings.HasSuffix(full, "."+key) {
+				best = full
+				break
+			}
+		}
+	}
+	if best == "" {
+		return "unknown key"
+	}
+	return fmt.Sprintf("unknown key, did you mean %q?", best)
+}
+
+// settableKeys lists the dotted paths of all leaves of Config.
+func settableKeys() []string {
+	var keys []string
+	var walk func(prefix string, t reflect.Type)
+	walk = func(prefix string, t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			key := prefix + jsonName(f)
+			if f.Type.Kind() == reflect.Struct {
+				walk(key+".", f.Type)
+				continue
+			}
+			keys = append(keys, key)
+		}
+	}
+	walk("", reflect.TypeOf(Config{}))
+	sort.Strings(keys)
+	return keys
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// walkValues calls fn for every leaf of a parsed JSON value, descending into
+// objects and lists.
+func walkValues(path string, v any, fn func(path string, v any)) {
+	switch n := v.(type) {
+	case map[string]any:
+		for _, key := range sortedKeys(n) {
+			walkValues(joinPath(path, key), n[key], fn)
+		}
+	case []any:
+		for i, item := range n {
+			walkValues(fmt.Sprintf("%s[%d]", path, i), item, fn)
+		}
+	default:
+		fn(path, v)
+	}
+}
+
+// keyLines maps the path of every key and list element in a JSON document
+// to the line it starts on.
+func keyLines(data []byte) map[string]int {
+	lines := map[string]int{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var walk func(path string) error
+	walk = func(path string) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'):
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				key, _ := keyTok.(string)
+				p := joinPath(path, key)
+				lines[p] = lineAt(data, dec.InputOffset()-1)
+				if err := walk(p); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+			return err
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				p := fmt.Sprintf("%s[%d]", path, i)
+				lines[p] = lineAt(data, nextValue(data, dec.InputOffset()))
+				if err := walk(p); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+			return err
+		}
+		return nil
+	}
+	_ = walk("")
+	return lines
+}
+
+// nextValue skips the separators between the decoder's offset and the next
+// value.
+func nextValue(data []byte, off int64) int64 {
+	for off < int64(len(data)) && strings.ContainsRune(" \t\r\n,:", rune(data[off])) {
+		off++
+	}
+	return off
+}
+
+func lineAt(data []byte, off int64) int {
+	if off > int64(len(data)) {
+		off = int64(len(data))
+	}
+	if off < 0 {
+		off = 0
+	}
+	return bytes.Count(data[:off], []byte("\n")) + 1
+}
+
+var indexPattern = regexp.MustCompile(`\[\d+\]`)
+
+func stripIndexes(path string) string {
+	return indexPattern.ReplaceAllString(path, "[]")
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func jsonKind(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	case []any:
+		return "a list"
+	case map[string]any:
+		return "an object"
+	}
+	return fmt.Sprintf("%T", v)
+}
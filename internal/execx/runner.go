@@ -25,6 +25,7 @@ type Options struct {
 	RequireSudo bool
 	Prompt      string
 	Env         map[string]string
+	Dir         string
 }
 
 func NewRunner() *Runner {
@@ -39,7 +40,7 @@ func NewRunner() *Runner {
 
 func (r *Runner) Run(ctx context.Context, name string, args []string, opts Options) error {
 	if opts.Sensitive {
-		ok, err := r.confirm(opts.Prompt)
+		ok, err := r.Confirm(opts.Prompt)
 		if err != nil {
 			return err
 		}
@@ -61,12 +62,14 @@ func (r *Runner) Run(ctx context.Context, name string, args []string, opts Optio
 			sudoArgs = append(sudoArgs, name)
 		}
 		sudoArgs = append(sudoArgs, args...)
-		return r.exec(ctx, "sudo", sudoArgs, opts.Env)
+		return r.exec(ctx, "sudo", sudoArgs, opts)
 	}
-	return r.exec(ctx, name, args, opts.Env)
+	return r.exec(ctx, name, args, opts)
 }
 
-func (r *Runner) confirm(prompt string) (bool, error) {
+// Confirm asks a yes/no question on the terminal and reports whether the user
+// answered yes. It fails without a TTY rather than assuming an answer.
+func (r *Runner) Confirm(prompt string) (bool, error) {
 	if !r.IsTTY() {
 		return false, errors.New("confirmation requires a TTY")
 	}
@@ -92,13 +95,14 @@ func (r *Runner) ensureSudo(ctx context.Context) error {
 	return cmd.Run()
 }
 
-func (r *Runner) exec(ctx context.Context, name string, args []string, env map[string]string) error {
+func (r *Runner) exec(ctx context.Context, name string, args []string, opts Options) error {
 	cmd := exec.CommandContext(ctx, name, args...)
 	cmd.Stdin = r.In
 	cmd.Stdout = r.Out
 	cmd.Stderr = r.Err
-	if len(env) > 0 {
-		cmd.Env = append(os.Environ(), envArgs(env)...)
+	cmd.Dir = opts.Dir
+	if len(opts.Env) > 0 {
+		cmd.Env = append(os.Environ(), envArgs(opts.Env)...)
 	}
 	return cmd.Run()
 }
@@ -57,6 +57,16 @@ func (r *Resolver) Resolve(ctx context.Context) (string, []string) {
 	return "", append([]string{}, r.mirrors...)
 }
 
+// Mirrors returns the mirror prefixes in probe order.
+func (r *Resolver) Mirrors() []string {
+	return append([]string{}, r.mirrors...)
+}
+
+// Probe reports whether the probe URL can be fetched through prefix.
+func (r *Resolver) Probe(ctx context.Context, prefix string) bool {
+	return r.probe(ctx, prefix)
+}
+
 func (r *Resolver) probe(parent context.Context, prefix string) bool {
 	ctx, cancel := context.WithTimeout(parent, r.timeout)
 	defer cancel()
@@ -22,27 +22,28 @@ const (
 )
 
 type Source struct {
-	Name string
-	URL  string
+	Name string `json:"name"`
+	URL  string `json:"url"`
 }
 
 type Attempt struct {
-	Source     Source
-	Attempt    int
-	StartedAt  time.Time
-	EndedAt    time.Time
-	DurationMS int64
-	Error      string
+	Source     Source    `json:"source"`
+	Attempt    int       `json:"attempt"`
+	StartedAt  time.Time `json:"started_at"`
+	EndedAt    time.Time `json:"ended_at"`
+	DurationMS int64     `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
 }
 
 type Report struct {
-	Operation  Operation
-	Target     string
-	StartedAt  time.Time
-	EndedAt    time.Time
-	Success    bool
-	UsedSource Source
-	Attempts   []Attempt
+	Operation  Operation `json:"operation"`
+	Target     string    `json:"target"`
+	Ref        string    `json:"ref,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	EndedAt    time.Time `json:"ended_at"`
+	Success    bool      `json:"success"`
+	UsedSource Source    `json:"used_source"`
+	Attempts   []Attempt `json:"attempts"`
 }
 
 type Manager struct {
@@ -55,7 +56,14 @@ func New(cfg config.Git, logger *logging.Logger) *Manager {
 }
 
 func (m *Manager) Clone(ctx context.Context, repoURL string, destination string) (Report, error) {
-	report := Report{Operation: OperationClone, Target: destination, StartedAt: time.Now().UTC()}
+	return m.CloneRef(ctx, repoURL, "", destination)
+}
+
+// CloneRef clones repoURL into destination and checks out ref, which may be a
+// branch or tag. An empty ref uses the remote's default branch.
+func (m *Manager) CloneRef(ctx context.Context, repoURL string, ref string, destination string) (Report, error) {
+	ref = strings.TrimSpace(ref)
+	report := Report{Operation: OperationClone, Target: destination, Ref: ref, StartedAt: time.Now().UTC()}
 	sources := buildSources(repoURL, m.cfg)
 	if len(sources) == 0 {
 		report.EndedAt = time.Now().UTC()
@@ -64,7 +72,11 @@ func (m *Manager) Clone(ctx context.Context, repoURL string, destination string)
 	for _, src := range sources {
 		for attempt := 1; attempt <= m.cfg.RetryPerSource; attempt++ {
 			one := Attempt{Source: src, Attempt: attempt, StartedAt: time.Now().UTC()}
-			args := []string{"clone", src.URL, destination}
+			args := []string{"clone"}
+			if ref != "" {
+				args = append(args, "--branch", ref)
+			}
+			args = append(args, src.URL, destination)
 			err := m.runGit(ctx, args, "")
 			one.EndedAt = time.Now().UTC()
 			one.DurationMS = one.EndedAt.Sub(one.StartedAt).Milliseconds()
@@ -89,26 +101,111 @@ func (m *Manager) Clone(ctx context.Context, repoURL string, destination string)
 	return report, fmt.Errorf("git clone failed after trying %d sources", len(sources))
 }
 
+// Pull fast-forwards repoDir from its upstream. Like Clone it walks the mirror
+// sources built from the remote's URL, fetching by URL so the repo's configured
+// remote is never rewritten.
 func (m *Manager) Pull(ctx context.Context, repoDir string) (Report, error) {
 	report := Report{Operation: OperationPull, Target: repoDir, StartedAt: time.Now().UTC()}
-	args := []string{"-C", repoDir, "pull", "--ff-only"}
-	one := Attempt{Source: Source{Name: "origin", URL: "(configured in repo)"}, Attempt: 1, StartedAt: time.Now().UTC()}
-	err := m.runGit(ctx, args, repoDir)
-	one.EndedAt = time.Now().UTC()
-	one.DurationMS = one.EndedAt.Sub(one.StartedAt).Milliseconds()
+	remote, branch, err := m.upstream(ctx, repoDir)
 	if err != nil {
-		one.Error = err.Error()
-		report.Attempts = append(report.Attempts, one)
 		report.EndedAt = time.Now().UTC()
-		m.warnf("git pull failed dir=%s err=%v", repoDir, err)
 		return report, err
 	}
-	report.Attempts = append(report.Attempts, one)
-	report.Success = true
-	report.UsedSource = one.Source
+	report.Ref = branch
+	out, err := m.outputGit(ctx, []string{"-C", repoDir, "remote", "get-url", remote}, repoDir)
+	if err != nil {
+		report.EndedAt = time.Now().UTC()
+		return report, fmt.Errorf("read url of remote %q: %w", remote, err)
+	}
+	sources := buildSources(strings.TrimSpace(out), m.cfg)
+	refspec := fmt.Sprintf("+refs/heads/%s:refs/remotes/%s/%s", branch, remote, branch)
+
+	for _, src := range sources {
+		for attempt := 1; attempt <= m.cfg.RetryPerSource; attempt++ {
+			one := Attempt{Source: src, Attempt: attempt, StartedAt: time.Now().UTC()}
+			err := m.runGit(ctx, []string{"-C", repoDir, "fetch", src.URL, refspec}, repoDir)
+			one.EndedAt = time.Now().UTC()
+			one.DurationMS = one.EndedAt.Sub(one.StartedAt).Milliseconds()
+			if err != nil {
+				one.Error = err.Error()
+				report.Attempts = append(report.Attempts, one)
+				m.warnf("git fetch failed source=%s attempt=%d err=%v", src.Name, attempt, err)
+				if attempt < m.cfg.RetryPerSource {
+					time.Sleep(time.Duration(m.cfg.RetryBackoffSeconds) * time.Second)
+				}
+				continue
+			}
+			report.Attempts = append(report.Attempts, one)
+			report.UsedSource = src
+			// A merge failure is not a transport problem, so other sources
+			// would not help.
+			if err := m.runGit(ctx, []string{"-C", repoDir, "merge", "--ff-only", "FETCH_HEAD"}, repoDir); err != nil {
+				report.EndedAt = time.Now().UTC()
+				m.warnf("git pull failed dir=%s err=%v", repoDir, err)
+				return report, err
+			}
+			report.Success = true
+			report.EndedAt = time.Now().UTC()
+			m.okf("git pull success source=%s dir=%s", src.Name, repoDir)
+			return report, nil
+		}
+	}
 	report.EndedAt = time.Now().UTC()
-	m.okf("git pull success dir=%s", repoDir)
-	return report, nil
+	return report, fmt.Errorf("git pull failed after trying %d sources", len(sources))
+}
+
+// upstream resolves the remote and branch the current branch tracks, falling
+// back to origin and the local branch name.
+func (m *Manager) upstream(ctx context.Context, repoDir string) (string, string, error) {
+	branch, err := m.Branch(ctx, repoDir)
+	if err != nil {
+		return "", "", err
+	}
+	if branch == "" || branch == "HEAD" {
+		return "", "", fmt.Errorf("repository %s is not on a branch", repoDir)
+	}
+	out, err := m.outputGit(ctx, []string{"-C", repoDir, "rev-parse", "--abbrev-ref", "--symbolic-full-name", "@{upstream}"}, repoDir)
+	if err == nil {
+		if remote, name, ok := strings.Cut(strings.TrimSpace(out), "/"); ok && remote != "" && name != "" {
+			return remote, name, nil
+		}
+	}
+	return "origin", branch, nil
+}
+
+// Head returns the commit currently checked out in repoDir.
+func (m *Manager) Head(ctx context.Context, repoDir string) (string, error) {
+	out, err := m.outputGit(ctx, []string{"-C", repoDir, "rev-parse", "HEAD"}, repoDir)
+	if err != nil {
+		return "", err
+	}
+	commit := strings.TrimSpace(out)
+	if commit == "" {
+		return "", fmt.Errorf("git rev-parse returned no commit for %s", repoDir)
+	}
+	return commit, nil
+}
+
+// Branch returns the branch checked out in repoDir, or "HEAD" when detached.
+func (m *Manager) Branch(ctx context.Context, repoDir string) (string, error) {
+	out, err := m.outputGit(ctx, []string{"-C", repoDir, "rev-parse", "--abbrev-ref", "HEAD"}, repoDir)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// Reset hard-resets repoDir to commit, discarding any partially applied update.
+func (m *Manager) Reset(ctx context.Context, repoDir string, commit string) error {
+	if strings.TrimSpace(commit) == "" {
+		return errors.New("reset commit is empty")
+	}
+	if err := m.runGit(ctx, []string{"-C", repoDir, "reset", "--hard", commit}, repoDir); err != nil {
+		m.warnf("git reset failed dir=%s commit=%s err=%v", repoDir, commit, err)
+		return err
+	}
+	m.okf("git reset success dir=%s commit=%s", repoDir, commit)
+	return nil
 }
 
 func (m *Manager) warnf(format string, args ...any) {
@@ -126,6 +223,11 @@ func (m *Manager) okf(format string, args ...any) {
 }
 
 func (m *Manager) runGit(ctx context.Context, args []string, workdir string) error {
+	_, err := m.outputGit(ctx, args, workdir)
+	return err
+}
+
+func (m *Manager) outputGit(ctx context.Context, args []string, workdir string) (string, error) {
 	timeout := time.Duration(m.cfg.CommandTimeoutSec) * time.Second
 	if timeout <= 0 {
 		timeout = 120 * time.Second
@@ -141,11 +243,11 @@ func (m *Manager) runGit(ctx context.Context, args []string, workdir string) err
 	if err != nil {
 		trimmed := strings.TrimSpace(string(out))
 		if trimmed != "" {
-			return fmt.Errorf("%w: %s", err, trimmed)
+			return "", fmt.Errorf("%w: %s", err, trimmed)
 		}
-		return err
+		return "", err
 	}
-	return nil
+	return string(out), nil
 }
 
 func buildSources(repoURL string, cfg config.Git) []Source {
@@ -84,3 +84,125 @@ func TestCloneFallbackAndRetry(t *testing.T) {
 		t.Fatalf("call lines = %d, want 3", len(lines))
 	}
 }
+
+func TestCloneRefPassesBranch(t *testing.T) {
+	fakeBin := t.TempDir()
+	logFile := filepath.Join(fakeBin, "git.calls")
+	body := "#!/bin/sh\n" +
+		"echo \"$@\" >> \"$GITOPS_CALLS\"\n" +
+		"exit 0\n"
+	if err := os.WriteFile(filepath.Join(fakeBin, "git"), []byte(body), 0o755); err != nil {
+		t.Fatalf("write fake git script: %v", err)
+	}
+	t.Setenv("PATH", fakeBin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("GITOPS_CALLS", logFile)
+
+	mgr := New(config.Git{RetryPerSource: 1, CommandTimeoutSec: 5}, nil)
+	report, err := mgr.CloneRef(context.Background(), "https://github.com/acme/repo.git", "dev", "/tmp/dest")
+	if err != nil {
+		t.Fatalf("clone error: %v", err)
+	}
+	if report.Ref != "dev" {
+		t.Fatalf("report ref = %q, want dev", report.Ref)
+	}
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("read call log: %v", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "clone --branch dev https://github.com/acme/repo.git /tmp/dest" {
+		t.Fatalf("git args = %q", got)
+	}
+}
+
+func TestHeadAndReset(t *testing.T) {
+	fakeBin := t.TempDir()
+	logFile := filepath.Join(fakeBin, "git.calls")
+	body := "#!/bin/sh\n" +
+		"echo \"$@\" >> \"$GITOPS_CALLS\"\n" +
+		"if [ \"$3\" = \"rev-parse\" ]; then echo 0123abcd; fi\n" +
+		"exit 0\n"
+	if err := os.WriteFile(filepath.Join(fakeBin, "git"), []byte(body), 0o755); err != nil {
+		t.Fatalf("write fake git script: %v", err)
+	}
+	t.Setenv("PATH", fakeBin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("GITOPS_CALLS", logFile)
+
+	repoDir := t.TempDir()
+	mgr := New(config.Git{CommandTimeoutSec: 5}, nil)
+	head, err := mgr.Head(context.Background(), repoDir)
+	if err != nil {
+		t.Fatalf("Head error: %v", err)
+	}
+	if head != "0123abcd" {
+		t.Fatalf("head = %q, want 0123abcd", head)
+	}
+	if err := mgr.Reset(context.Background(), repoDir, head); err != nil {
+		t.Fatalf("Reset error: %v", err)
+	}
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("read call log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || lines[1] != "-C "+repoDir+" reset --hard 0123abcd" {
+		t.Fatalf("git calls = %q", lines)
+	}
+}
+
+func TestPullFallbackKeepsRemote(t *testing.T) {
+	fakeBin := t.TempDir()
+	logFile := filepath.Join(fakeBin, "git.calls")
+	body := "#!/bin/sh\n" +
+		"echo \"$@\" >> \"$GITOPS_CALLS\"\n" +
+		"case \"$3\" in\n" +
+		"  rev-parse)\n" +
+		"    if [ \"$5\" = \"HEAD\" ]; then echo main; exit 0; fi\n" +
+		"    echo origin/main; exit 0 ;;\n" +
+		"  remote) echo https://github.com/acme/repo.git; exit 0 ;;\n" +
+		"  fetch)\n" +
+		"    case \"$4\" in\n" +
+		"      *mirror.local*) exit 2 ;;\n" +
+		"    esac\n" +
+		"    exit 0 ;;\n" +
+		"esac\n" +
+		"exit 0\n"
+	if err := os.WriteFile(filepath.Join(fakeBin, "git"), []byte(body), 0o755); err != nil {
+		t.Fatalf("write fake git script: %v", err)
+	}
+	t.Setenv("PATH", fakeBin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("GITOPS_CALLS", logFile)
+
+	mgr := New(config.Git{
+		Mirrors:             []config.GitMirror{{Name: "mirror", BaseURL: "https://mirror.local", Enabled: true}},
+		MirrorFirst:         true,
+		RetryPerSource:      2,
+		RetryBackoffSeconds: 0,
+		CommandTimeoutSec:   5,
+	}, nil)
+	repoDir := t.TempDir()
+	report, err := mgr.Pull(context.Background(), repoDir)
+	if err != nil {
+		t.Fatalf("pull error: %v", err)
+	}
+	if !report.Success || report.UsedSource.Name != "origin" {
+		t.Fatalf("report = %+v, want success from origin", report)
+	}
+	if len(report.Attempts) != 3 {
+		t.Fatalf("attempts = %d, want 3 (2 mirror + 1 origin)", len(report.Attempts))
+	}
+	if report.Attempts[0].Source.URL != "https://mirror.local/github.com/acme/repo.git" {
+		t.Fatalf("first attempt url = %q", report.Attempts[0].Source.URL)
+	}
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("read call log: %v", err)
+	}
+	calls := string(data)
+	if strings.Contains(calls, "set-url") || strings.Contains(calls, "remote add") {
+		t.Fatalf("pull modified remotes: %s", calls)
+	}
+	if !strings.Contains(calls, "merge --ff-only FETCH_HEAD") {
+		t.Fatalf("pull did not fast-forward: %s", calls)
+	}
+}
@@ -5,11 +5,54 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+
+	"maibot/internal/process"
 )
 
+// ErrLockHeld is returned by BreakLock when the lock is held and force was not
+// requested.
+var ErrLockHeld = errors.New("lock is held by another process")
+
+// Lock is an exclusive advisory lock on <dir>/<name>.lock. The kernel drops it
+// when the holder exits, so a crashed holder never blocks later callers.
 type Lock struct {
 	path string
+	file *os.File
+}
+
+// LockTimeoutError is returned when a lock is still held once the timeout
+// expires. PID is the holder recorded in the lock file, or 0 if unknown.
+type LockTimeoutError struct {
+	Path string
+	PID  int
+}
+
+func (e *LockTimeoutError) Error() string {
+	if e.PID > 0 {
+		return fmt.Sprintf("timed out waiting lock %s held by pid %d", e.Path, e.PID)
+	}
+	return fmt.Sprintf("timed out waiting lock %s", e.Path)
+}
+
+// LockInfo describes a lock file found by ListLocks.
+type LockInfo struct {
+	Name      string    `json:"name"`
+	Path      string    `json:"path"`
+	PID       int       `json:"pid"`
+	CreatedAt time.Time `json:"created_at"`
+	Held      bool      `json:"held"`
+	Alive     bool      `json:"alive"`
+}
+
+// Stale reports whether nobody holds the lock any more. A held lock is live
+// even when the recorded pid is unknown or dead: the holder may not have
+// written it yet, or may have inherited the descriptor.
+func (i LockInfo) Stale() bool {
+	return !i.Held
 }
 
 func AcquireLock(dir, name string, timeout time.Duration) (*Lock, error) {
@@ -20,38 +63,191 @@ func AcquireLock(dir, name string, timeout time.Duration) (*Lock, error) {
 	deadline := time.Now().Add(timeout)
 
 	for {
-		f, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
-		if err == nil {
-			_, _ = fmt.Fprintf(f, "pid=%d\ncreated_unix=%d\n", os.Getpid(), time.Now().Unix())
-			_ = f.Close()
-			return &Lock{path: lockPath}, nil
-		}
-		if !errors.Is(err, os.ErrExist) {
-			return nil, err
-		}
-
-		stale, staleErr := isStale(lockPath, 10*time.Minute)
-		if staleErr == nil && stale {
-			_ = os.Remove(lockPath)
-			continue
+		lock, err := tryAcquire(lockPath)
+		if err != nil || lock != nil {
+			return lock, err
 		}
 
+		// The kernel lock is taken, even if the recorded pid is gone: the
+		// descriptor may live on in another process. The file is never
+		// removed here, or a second caller could lock a fresh inode while
+		// the holder keeps the old one; the lock is only taken over once
+		// the kernel drops it.
 		if time.Now().After(deadline) {
-			return nil, fmt.Errorf("timed out waiting lock %s", lockPath)
+			return nil, &LockTimeoutError{Path: lockPath, PID: lockHolder(lockPath)}
 		}
 		time.Sleep(120 * time.Millisecond)
 	}
 }
 
+// tryAcquire makes one non-blocking attempt. It returns a nil lock and nil
+// error when another process holds the lock.
+func tryAcquire(lockPath string) (*Lock, error) {
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	locked, err := tryLockFile(f)
+	if err != nil || !locked {
+		_ = f.Close()
+		return nil, err
+	}
+	// The previous holder may have removed the file between our open and
+	// lock; only the inode currently at lockPath counts.
+	if !sameFile(f, lockPath) {
+		_ = unlockFile(f)
+		_ = f.Close()
+		return nil, nil
+	}
+	if err := f.Truncate(0); err != nil {
+		_ = unlockFile(f)
+		_ = f.Close()
+		return nil, err
+	}
+	_, _ = fmt.Fprintf(f, "pid=%d\ncreated_unix=%d\n", os.Getpid(), time.Now().Unix())
+	_ = f.Sync()
+	return &Lock{path: lockPath, file: f}, nil
+}
+
 func (l *Lock) Release() error {
 	if l == nil || l.path == "" {
 		return nil
 	}
-	err := os.Remove(l.path)
-	if errors.Is(err, os.ErrNotExist) {
+	// Remove while still holding the lock so waiters re-check the inode.
+	removeErr := os.Remove(l.path)
+	if l.file != nil {
+		_ = unlockFile(l.file)
+		_ = l.file.Close()
+		l.file = nil
+	}
+	if removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+		// Windows refuses to remove an open file; retry once it is closed.
+		removeErr = os.Remove(l.path)
+	}
+	if errors.Is(removeErr, os.ErrNotExist) {
 		return nil
 	}
-	return err
+	return removeErr
+}
+
+// ListLocks inspects every lock file in dir without taking any of them.
+func ListLocks(dir string) ([]LockInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	out := make([]LockInfo, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".lock") {
+			continue
+		}
+		info, err := inspectLock(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// BreakLock removes the named lock. A held lock is only removed when force is
+// set; otherwise the lock is taken over and released, so that one acquired
+// since it was inspected is left alone.
+func BreakLock(dir, name string, force bool) (LockInfo, error) {
+	lockPath := filepath.Join(dir, sanitizeLockName(strings.TrimSuffix(name, ".lock"))+".lock")
+	info, err := inspectLock(lockPath)
+	if err != nil {
+		return LockInfo{}, err
+	}
+	if force {
+		if err := os.Remove(lockPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return info, err
+		}
+		return info, nil
+	}
+	if !info.Stale() {
+		return info, ErrLockHeld
+	}
+	lock, err := tryAcquire(lockPath)
+	if err != nil {
+		return info, err
+	}
+	if lock == nil {
+		return info, ErrLockHeld
+	}
+	return info, lock.Release()
+}
+
+func inspectLock(lockPath string) (LockInfo, error) {
+	st, err := os.Stat(lockPath)
+	if err != nil {
+		return LockInfo{}, err
+	}
+	info := LockInfo{
+		Name:      strings.TrimSuffix(filepath.Base(lockPath), ".lock"),
+		Path:      lockPath,
+		CreatedAt: st.ModTime().UTC(),
+	}
+	fields := readLockFields(lockPath)
+	info.PID, _ = strconv.Atoi(fields["pid"])
+	if unix, err := strconv.ParseInt(fields["created_unix"], 10, 64); err == nil && unix > 0 {
+		info.CreatedAt = time.Unix(unix, 0).UTC()
+	}
+	info.Alive = info.PID > 0 && process.IsAlive(info.PID)
+
+	f, err := os.Open(lockPath)
+	if err != nil {
+		return LockInfo{}, err
+	}
+	defer f.Close()
+	locked, err := tryLockFile(f)
+	if err != nil {
+		return LockInfo{}, err
+	}
+	if locked {
+		_ = unlockFile(f)
+	}
+	info.Held = !locked
+	return info, nil
+}
+
+// lockHolder returns the pid recorded in a lock file, or 0 if it cannot be read.
+func lockHolder(path string) int {
+	pid, _ := strconv.Atoi(readLockFields(path)["pid"])
+	return pid
+}
+
+func readLockFields(path string) map[string]string {
+	fields := map[string]string{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fields
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			fields[key] = value
+		}
+	}
+	return fields
+}
+
+func sameFile(f *os.File, path string) bool {
+	held, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(held, current)
 }
 
 func sanitizeLockName(name string) string {
@@ -78,11 +274,3 @@ func sanitizeLockName(name string) string {
 	}
 	return string(out)
 }
-
-func isStale(path string, age time.Duration) (bool, error) {
-	info, err := os.Stat(path)
-	if err != nil {
-		return false, err
-	}
-	return time.Since(info.ModTime()) > age, nil
-}
@@ -1,6 +1,8 @@
 package instance
 
 import (
+	"errors"
+	"os"
 	"path/filepath"
 	"testing"
 	"time"
@@ -29,8 +31,123 @@ func TestAcquireLockTimeout(t *testing.T) {
 	if err == nil {
 		t.Fatalf("expected timeout error")
 	}
+	var timeoutErr *LockTimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected LockTimeoutError, got %T", err)
+	}
+	if timeoutErr.PID != os.Getpid() {
+		t.Fatalf("holder pid = %d, want %d", timeoutErr.PID, os.Getpid())
+	}
 
 	if _, statErr := filepath.Glob(filepath.Join(dir, "*.lock")); statErr != nil {
 		t.Fatalf("glob lock error: %v", statErr)
 	}
 }
+
+func TestAcquireLockIgnoresDeadHolder(t *testing.T) {
+	dir := t.TempDir()
+	// A lock file left behind by a crashed process: no kernel lock, dead pid.
+	if err := os.WriteFile(filepath.Join(dir, "demo.lock"), []byte("pid=999999999\ncreated_unix=1\n"), 0o644); err != nil {
+		t.Fatalf("write lock file: %v", err)
+	}
+	lock, err := AcquireLock(dir, "demo", 0)
+	if err != nil {
+		t.Fatalf("AcquireLock error: %v", err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Release error: %v", err)
+	}
+}
+
+func TestListAndBreakLocks(t *testing.T) {
+	dir := t.TempDir()
+	lock, err := AcquireLock(dir, "demo", time.Second)
+	if err != nil {
+		t.Fatalf("AcquireLock error: %v", err)
+	}
+	defer func() { _ = lock.Release() }()
+
+	infos, err := ListLocks(dir)
+	if err != nil {
+		t.Fatalf("ListLocks error: %v", err)
+	}
+	if len(infos) != 1 || infos[0].Name != "demo" || infos[0].PID != os.Getpid() || !infos[0].Held || infos[0].Stale() {
+		t.Fatalf("unexpected locks: %+v", infos)
+	}
+
+	if _, err := BreakLock(dir, "demo", false); !errors.Is(err, ErrLockHeld) {
+		t.Fatalf("BreakLock without force = %v, want ErrLockHeld", err)
+	}
+	if _, err := BreakLock(dir, "demo", true); err != nil {
+		t.Fatalf("BreakLock with force error: %v", err)
+	}
+	if infos, _ := ListLocks(dir); len(infos) != 0 {
+		t.Fatalf("lock still listed after break: %+v", infos)
+	}
+}
+
+func TestHeldLockWithoutLivePIDIsNotStale(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "demo.lock")
+	// A holder that has not written its pid yet, or whose recorded pid is
+	// gone while an inherited descriptor keeps the lock.
+	for _, content := range []string{"", "pid=0\n", "pid=999999999\n"} {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+		if err != nil {
+			t.Fatalf("open lock file: %v", err)
+		}
+		if locked, err := tryLockFile(f); err != nil || !locked {
+			t.Fatalf("tryLockFile = %v, %v", locked, err)
+		}
+		if _, err := f.WriteString(content); err != nil {
+			t.Fatalf("write lock file: %v", err)
+		}
+
+		infos, err := ListLocks(dir)
+		if err != nil || len(infos) != 1 || !infos[0].Held || infos[0].Stale() {
+			t.Fatalf("ListLocks with %q = %+v, %v; want held and not stale", content, infos, err)
+		}
+		if _, err := BreakLock(dir, "demo", false); !errors.Is(err, ErrLockHeld) {
+			t.Fatalf("BreakLock without force with %q = %v, want ErrLockHeld", content, err)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("held lock was removed: %v", err)
+		}
+		_ = unlockFile(f)
+		_ = f.Close()
+	}
+}
+
+func TestAcquireLockWaitsForHolderWithDeadPID(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "demo.lock")
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatalf("open lock file: %v", err)
+	}
+	if locked, err := tryLockFile(f); err != nil || !locked {
+		t.Fatalf("tryLockFile = %v, %v", locked, err)
+	}
+	if _, err := f.WriteString("pid=999999999\n"); err != nil {
+		t.Fatalf("write lock file: %v", err)
+	}
+
+	var timeoutErr *LockTimeoutError
+	if _, err := AcquireLock(dir, "demo", 200*time.Millisecond); !errors.As(err, &timeoutErr) {
+		t.Fatalf("AcquireLock while held = %v, want LockTimeoutError", err)
+	}
+	if !sameFile(f, path) {
+		t.Fatalf("held lock file was replaced")
+	}
+
+	// Once the holder lets go the lock is taken over.
+	_ = unlockFile(f)
+	_ = f.Close()
+	lock, err := AcquireLock(dir, "demo", time.Second)
+	if err != nil {
+		t.Fatalf("AcquireLock after release error: %v", err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Release error: %v", err)
+	}
+}
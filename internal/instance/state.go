@@ -28,21 +28,23 @@ var allowedTransitions = map[string]map[string]bool{
 		StateFailed:   true,
 	},
 	StateStopped: {
-		StateStopped:  true,
-		StateRunning:  true,
-		StateUpdating: true,
-		StateFailed:   true,
+		StateStopped:   true,
+		StateInstalled: true,
+		StateRunning:   true,
+		StateUpdating:  true,
+		StateFailed:    true,
 	},
+	// An update is exclusive: it cannot be re-entered or jump straight to
+	// running; it settles first and the instance is started from there.
 	StateUpdating: {
-		StateUpdating:  true,
 		StateInstalled: true,
-		StateRunning:   true,
 		StateStopped:   true,
 		StateFailed:    true,
 	},
 	StateFailed: {
 		StateFailed:    true,
 		StateInstalled: true,
+		StateRunning:   true,
 		StateUpdating:  true,
 		StateStopped:   true,
 	},
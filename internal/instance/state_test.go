@@ -10,3 +10,15 @@ func TestValidateTransition(t *testing.T) {
 		t.Fatalf("expected invalid transition error")
 	}
 }
+
+func TestUpdatingIsExclusive(t *testing.T) {
+	if err := ValidateTransition(StateUpdating, StateUpdating); err == nil {
+		t.Fatalf("expected re-entering updating to be rejected")
+	}
+	if err := ValidateTransition(StateUpdating, StateRunning); err == nil {
+		t.Fatalf("expected updating -> running to be rejected")
+	}
+	if err := ValidateTransition(StateFailed, StateRunning); err != nil {
+		t.Fatalf("expected failed -> running to be allowed, got %v", err)
+	}
+}
@@ -1,10 +1,13 @@
 package logging
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
@@ -24,21 +27,10 @@ type Logger struct {
 }
 
 func NewRoot(opts Options) (*Logger, error) {
-	if strings.TrimSpace(opts.FilePath) == "" {
-		return nil, fmt.Errorf("log file path is empty")
-	}
-	if err := os.MkdirAll(filepath.Dir(opts.FilePath), 0o755); err != nil {
+	roller, err := NewRotatingFile(opts)
+	if err != nil {
 		return nil, err
 	}
-	if opts.MaxSizeMB <= 0 {
-		opts.MaxSizeMB = 10
-	}
-	if opts.RetentionDays <= 0 {
-		opts.RetentionDays = 7
-	}
-	if opts.MaxBackupFiles <= 0 {
-		opts.MaxBackupFiles = 20
-	}
 
 	consoleEncoderCfg := zapcore.EncoderConfig{
 		TimeKey:          "time",
@@ -56,39 +48,84 @@ func NewRoot(opts Options) (*Logger, error) {
 		consoleEncoderCfg.EncodeLevel = zapcore.CapitalLevelEncoder
 	}
 
-	fileEncoderCfg := zapcore.EncoderConfig{
-		TimeKey:          "time",
-		LevelKey:         "level",
-		NameKey:          "module",
-		MessageKey:       "msg",
-		EncodeTime:       longTimeEncoder,
-		EncodeLevel:      zapcore.CapitalLevelEncoder,
-		EncodeDuration:   zapcore.StringDurationEncoder,
-		EncodeCaller:     zapcore.ShortCallerEncoder,
-		ConsoleSeparator: " | ",
-	}
-
 	consoleCore := zapcore.NewCore(
 		zapcore.NewConsoleEncoder(consoleEncoderCfg),
 		zapcore.Lock(os.Stdout),
 		zapcore.InfoLevel,
 	)
 
-	roller := &lumberjack.Logger{
+	fileCore := zapcore.NewCore(
+		zapcore.NewConsoleEncoder(fileEncoderConfig()),
+		zapcore.AddSync(roller),
+		zapcore.InfoLevel,
+	)
+
+	base := zap.New(zapcore.NewTee(consoleCore, fileCore), zap.AddCallerSkip(1))
+	return &Logger{zap: base.Sugar()}, nil
+}
+
+// NewRotatingFile returns a writer appending to opts.FilePath that rotates it
+// into "<name>-<timestamp><ext>" segments next to it once it exceeds
+// MaxSizeMB, keeping at most MaxBackupFiles segments for RetentionDays.
+func NewRotatingFile(opts Options) (io.WriteCloser, error) {
+	if strings.TrimSpace(opts.FilePath) == "" {
+		return nil, fmt.Errorf("log file path is empty")
+	}
+	if err := os.MkdirAll(filepath.Dir(opts.FilePath), 0o755); err != nil {
+		return nil, err
+	}
+	if opts.MaxSizeMB <= 0 {
+		opts.MaxSizeMB = 10
+	}
+	if opts.RetentionDays <= 0 {
+		opts.RetentionDays = 7
+	}
+	if opts.MaxBackupFiles <= 0 {
+		opts.MaxBackupFiles = 20
+	}
+	return &lumberjack.Logger{
 		Filename:   opts.FilePath,
 		MaxSize:    opts.MaxSizeMB,
 		MaxBackups: opts.MaxBackupFiles,
 		MaxAge:     opts.RetentionDays,
 		Compress:   false,
+	}, nil
+}
+
+// Segments lists the rotated segments of path followed by path itself,
+// oldest first. Missing files are skipped.
+func Segments(path string) ([]string, error) {
+	dir, base := filepath.Split(path)
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "-"
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return nil, err
 	}
-	fileCore := zapcore.NewCore(
-		zapcore.NewConsoleEncoder(fileEncoderCfg),
-		zapcore.AddSync(roller),
+	var out []string
+	// Entries come sorted by name, and the timestamp suffix sorts
+	// chronologically as text.
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, prefix) && strings.HasSuffix(name, ext) {
+			out = append(out, filepath.Join(dir, name))
+		}
+	}
+	if _, err := os.Stat(path); err == nil {
+		out = append(out, path)
+	}
+	return out, nil
+}
+
+// NewSink returns a logger that writes file-format lines to w only, without
+// echoing to the console or the installer log.
+func NewSink(w io.Writer) *Logger {
+	core := zapcore.NewCore(
+		zapcore.NewConsoleEncoder(fileEncoderConfig()),
+		zapcore.AddSync(w),
 		zapcore.InfoLevel,
 	)
-
-	base := zap.New(zapcore.NewTee(consoleCore, fileCore), zap.AddCallerSkip(1))
-	return &Logger{zap: base.Sugar()}, nil
+	return &Logger{zap: zap.New(core).Sugar()}
 }
 
 func (l *Logger) Module(module string) *Logger {
@@ -119,6 +156,79 @@ func (l *Logger) Fatalf(format string, args ...any) {
 	l.zap.Fatalf(format, args...)
 }
 
+// maxLineBytes caps a line held back while waiting for its newline. Longer
+// output is logged in pieces of this size.
+const maxLineBytes = 64 << 10
+
+// Writer returns a writer that logs every complete line written to it at
+// info level. Close flushes a trailing partial line.
+func (l *Logger) Writer() io.WriteCloser {
+	return &lineWriter{log: l, level: zapcore.InfoLevel}
+}
+
+// WarnWriter is Writer at warn level, for a child's stderr.
+func (l *Logger) WarnWriter() io.WriteCloser {
+	return &lineWriter{log: l, level: zapcore.WarnLevel}
+}
+
+type lineWriter struct {
+	mu    sync.Mutex
+	log   *Logger
+	level zapcore.Level
+	buf   []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		idx := bytes.IndexByte(w.buf, '\n')
+		if idx < 0 {
+			break
+		}
+		w.emit(w.buf[:idx])
+		w.buf = w.buf[idx+1:]
+	}
+	for len(w.buf) >= maxLineBytes {
+		w.emit(w.buf[:maxLineBytes])
+		w.buf = w.buf[maxLineBytes:]
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.emit(w.buf)
+		w.buf = nil
+	}
+	return nil
+}
+
+func (w *lineWriter) emit(line []byte) {
+	text := strings.TrimRight(string(line), "\r")
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	w.log.zap.Log(w.level, text)
+}
+
+func fileEncoderConfig() zapcore.EncoderConfig {
+	return zapcore.EncoderConfig{
+		TimeKey:          "time",
+		LevelKey:         "level",
+		NameKey:          "module",
+		MessageKey:       "msg",
+		EncodeTime:       longTimeEncoder,
+		EncodeLevel:      zapcore.CapitalLevelEncoder,
+		EncodeDuration:   zapcore.StringDurationEncoder,
+		EncodeCaller:     zapcore.ShortCallerEncoder,
+		ConsoleSeparator: " | ",
+	}
+}
+
 func shortTimeEncoder(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
 	enc.AppendString(t.Format("01-02 15:04"))
 }
@@ -6,6 +6,7 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoggerWritesModuleLineToFile(t *testing.T) {
@@ -51,3 +52,69 @@ func TestLoggerRotationAndCleanup(t *testing.T) {
 		t.Fatalf("backup files = %d, want <= 1", len(files))
 	}
 }
+
+func TestParseLine(t *testing.T) {
+	now := time.Date(2025, 1, 2, 10, 0, 0, 0, time.Local)
+
+	entry, ok := ParseLine("2024-12-31 23:59:58 | WARN | instance | child exited | code=1", now)
+	if !ok {
+		t.Fatalf("expected file-format line to parse")
+	}
+	if !entry.Time.Equal(time.Date(2024, 12, 31, 23, 59, 58, 0, time.Local)) || entry.Level != "WARN" || entry.Module != "instance" || entry.Message != "child exited | code=1" {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+
+	// The console format has no year; December is last year in January.
+	entry, ok = ParseLine("12-31 23:59 | INFO | app | hi", now)
+	if !ok || entry.Time.Year() != 2024 || entry.Module != "app" {
+		t.Fatalf("unexpected short entry ok=%v %+v", ok, entry)
+	}
+
+	if _, ok := ParseLine("Traceback (most recent call last):", now); ok {
+		t.Fatalf("expected continuation line not to parse")
+	}
+}
+
+func TestSegmentsOrdersRotatedFilesFirst(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"workspace.log", "workspace-2025-01-02T00-00-00.000.log", "workspace-2025-01-01T00-00-00.000.log", "worker.out"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	got, err := Segments(filepath.Join(dir, "workspace.log"))
+	if err != nil {
+		t.Fatalf("Segments error: %v", err)
+	}
+	want := []string{"workspace-2025-01-01T00-00-00.000.log", "workspace-2025-01-02T00-00-00.000.log", "workspace.log"}
+	if len(got) != len(want) {
+		t.Fatalf("Segments = %v, want %v", got, want)
+	}
+	for i := range want {
+		if filepath.Base(got[i]) != want[i] {
+			t.Fatalf("Segments = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestLineWriterLevelsAndLongLines(t *testing.T) {
+	var out strings.Builder
+	log := NewSink(&out).Module("maibot")
+	stdout, stderr := log.Writer(), log.WarnWriter()
+	_, _ = stdout.Write([]byte("ready\n"))
+	_, _ = stderr.Write([]byte("Traceback\n"))
+	_, _ = stdout.Write([]byte(strings.Repeat("x", maxLineBytes+10)))
+	_ = stdout.Close()
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines, want 4:\n%s", len(lines), out.String())
+	}
+	if !strings.Contains(lines[0], "| INFO | maibot | ready") || !strings.Contains(lines[1], "| WARN | maibot | Traceback") {
+		t.Fatalf("unexpected levels:\n%s", out.String())
+	}
+	// The unterminated line is split at the cap instead of buffered whole.
+	if !strings.HasSuffix(lines[2], "| "+strings.Repeat("x", maxLineBytes)) || !strings.HasSuffix(lines[3], "| xxxxxxxxxx") {
+		t.Fatalf("long line was not split at %d bytes", maxLineBytes)
+	}
+}
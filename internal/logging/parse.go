@@ -0,0 +1,78 @@
+package logging
+
+import (
+	"strings"
+	"time"
+)
+
+// Entry is one line written by a Logger, split back into its columns.
+type Entry struct {
+	Time    time.Time
+	Level   string
+	Module  string
+	Message string
+}
+
+var levelRank = map[string]int{
+	"DEBUG":  0,
+	"INFO":   1,
+	"WARN":   2,
+	"ERROR":  3,
+	"DPANIC": 4,
+	"PANIC":  5,
+	"FATAL":  6,
+}
+
+// LevelRank orders level names by severity. Unknown names rank -1.
+func LevelRank(level string) int {
+	rank, ok := levelRank[strings.ToUpper(strings.TrimSpace(level))]
+	if !ok {
+		return -1
+	}
+	return rank
+}
+
+// ParseLine parses a line in the file format ("2006-01-02 15:04:05 | LEVEL |
+// module | msg") or the console format ("01-02 15:04 | ..."). The console
+// format carries no year; it is taken from now, or the year before if that
+// would put the entry in the future. Lines that do not match, such as
+// continuation lines of a multi-line message, return false.
+func ParseLine(line string, now time.Time) (Entry, bool) {
+	parts := strings.SplitN(line, " | ", 4)
+	if len(parts) < 3 {
+		return Entry{}, false
+	}
+	ts, ok := parseTimestamp(parts[0], now)
+	if !ok {
+		return Entry{}, false
+	}
+	level := strings.TrimSpace(parts[1])
+	if LevelRank(level) < 0 {
+		return Entry{}, false
+	}
+	entry := Entry{Time: ts, Level: level}
+	// A logger without a name writes no module column.
+	if len(parts) == 4 {
+		entry.Module = strings.TrimSpace(parts[2])
+		entry.Message = parts[3]
+	} else {
+		entry.Message = parts[2]
+	}
+	return entry, true
+}
+
+func parseTimestamp(raw string, now time.Time) (time.Time, bool) {
+	raw = strings.TrimSpace(raw)
+	if ts, err := time.ParseInLocation("2006-01-02 15:04:05", raw, now.Location()); err == nil {
+		return ts, true
+	}
+	ts, err := time.ParseInLocation("01-02 15:04", raw, now.Location())
+	if err != nil {
+		return time.Time{}, false
+	}
+	ts = time.Date(now.Year(), ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), 0, 0, now.Location())
+	if ts.After(now.Add(24 * time.Hour)) {
+		ts = ts.AddDate(-1, 0, 0)
+	}
+	return ts, true
+}
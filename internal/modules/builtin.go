@@ -7,6 +7,12 @@ func BuiltinDefinitions() []config.ModuleDefinition {
 		{
 			Name:        "napcat",
 			Description: "Install NapCat runtime, LinuxQQ, launcher helper into workspace",
+			// NapCat's own config lives in its install dir; the QQ login lives
+			// in LinuxQQ's profile.
+			State:     []string{"modules/napcat/config", "~/.config/QQ"},
+			Downloads: []string{"modules/napcat/NapCat.Shell.zip", "modules/napcat/QQ.rpm", "modules/napcat/QQ.deb", "modules/napcat/tmp"},
+			// NapCat WebUI.
+			Ports: []int{6099},
 			Install: []config.ModuleStep{
 				{
 					Name:    "prepare workspace directories",
@@ -0,0 +1,58 @@
+package process
+
+import "time"
+
+// Identity pins a pid to one specific process. The start time and executable
+// are recorded where the platform exposes them, so a pid reused by an
+// unrelated process after the original exited is not mistaken for it.
+type Identity struct {
+	PID       int
+	StartTime uint64
+	Exe       string
+}
+
+// Lookup captures the identity of the running process pid. Fields the
+// platform cannot provide are left empty and are not checked later.
+func Lookup(pid int) Identity {
+	if pid <= 0 {
+		return Identity{}
+	}
+	return lookup(pid)
+}
+
+// Alive reports whether the process identified by id is still running.
+func (id Identity) Alive() bool {
+	if !IsAlive(id.PID) {
+		return false
+	}
+	return id.matches(lookup(id.PID))
+}
+
+// Terminate asks the process to exit if it is still the recorded one.
+func (id Identity) Terminate() error {
+	if !id.Alive() {
+		return nil
+	}
+	return Terminate(id.PID)
+}
+
+// Stop stops the process like Stop, but never signals a process whose
+// identity no longer matches.
+func (id Identity) Stop(grace time.Duration) error {
+	if !id.Alive() {
+		return nil
+	}
+	return stop(id.PID, grace, id.Alive)
+}
+
+func (id Identity) matches(current Identity) bool {
+	if id.StartTime != 0 && current.StartTime != id.StartTime {
+		return false
+	}
+	// The executable of another user's process may be unreadable; the start
+	// time alone still tells a reused pid apart.
+	if id.Exe != "" && current.Exe != "" && current.Exe != id.Exe {
+		return false
+	}
+	return true
+}
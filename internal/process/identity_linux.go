@@ -0,0 +1,44 @@
+//go:build linux
+
+package process
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func lookup(pid int) Identity {
+	id := Identity{PID: pid}
+	if data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid)); err == nil {
+		id.StartTime = parseStartTime(string(data))
+	}
+	if exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid)); err == nil {
+		// An upgraded binary keeps running from the unlinked inode.
+		id.Exe = strings.TrimSuffix(exe, " (deleted)")
+	}
+	return id
+}
+
+// parseStartTime extracts starttime (in clock ticks since boot) from
+// /proc/<pid>/stat.
+func parseStartTime(stat string) uint64 {
+	v, _ := strconv.ParseUint(statField(stat, 22), 10, 64)
+	return v
+}
+
+// statField returns field n (1-based, as numbered in proc(5)) of a
+// /proc/<pid>/stat line. The command name in field 2 may contain spaces and
+// parentheses, so fields are counted from the last ')'.
+func statField(stat string, n int) string {
+	end := strings.LastIndexByte(stat, ')')
+	if end < 0 || n < 3 {
+		return ""
+	}
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) <= n-3 {
+		return ""
+	}
+	return fields[n-3]
+}
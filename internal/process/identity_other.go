@@ -0,0 +1,7 @@
+//go:build !linux
+
+package process
+
+func lookup(pid int) Identity {
+	return Identity{PID: pid}
+}
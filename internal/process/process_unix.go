@@ -5,10 +5,20 @@ package process
 import (
 	"errors"
 	"fmt"
+	"os/exec"
 	"syscall"
 	"time"
 )
 
+// Detach makes cmd start in a new session, so it leads its own process group
+// and everything it spawns can be stopped together with Stop.
+func Detach(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+}
+
 func IsAlive(pid int) bool {
 	if pid <= 0 {
 		return false
@@ -17,6 +27,17 @@ func IsAlive(pid int) bool {
 	return err == nil
 }
 
+// Terminate asks pid to exit without waiting for it.
+func Terminate(pid int) error {
+	if pid <= 0 {
+		return nil
+	}
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return fmt.Errorf("failed SIGTERM pid %d: %w", pid, err)
+	}
+	return nil
+}
+
 func Stop(pid int, grace time.Duration) error {
 	if pid <= 0 {
 		return nil
@@ -24,8 +45,20 @@ func Stop(pid int, grace time.Duration) error {
 	if !IsAlive(pid) {
 		return nil
 	}
+	return stop(pid, grace, func() bool { return IsAlive(pid) })
+}
 
-	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+// stop sends SIGTERM, waits up to grace for alive to turn false and then
+// sends SIGKILL. A process group leader is signalled together with its whole
+// group, and the wait lasts until every member is gone.
+func stop(pid int, grace time.Duration, alive func() bool) error {
+	target := pid
+	if pgid, err := syscall.Getpgid(pid); err == nil && pgid == pid {
+		target = -pid
+		alive = func() bool { return syscall.Kill(-pid, syscall.Signal(0)) == nil }
+	}
+
+	if err := syscall.Kill(target, syscall.SIGTERM); err != nil {
 		if !errors.Is(err, syscall.ESRCH) {
 			return fmt.Errorf("failed SIGTERM pid %d: %w", pid, err)
 		}
@@ -34,13 +67,16 @@ func Stop(pid int, grace time.Duration) error {
 
 	deadline := time.Now().Add(grace)
 	for time.Now().Before(deadline) {
-		if !IsAlive(pid) {
+		if !alive() {
 			return nil
 		}
 		time.Sleep(150 * time.Millisecond)
 	}
+	if !alive() {
+		return nil
+	}
 
-	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+	if err := syscall.Kill(target, syscall.SIGKILL); err != nil {
 		if !errors.Is(err, syscall.ESRCH) {
 			return fmt.Errorf("failed SIGKILL pid %d: %w", pid, err)
 		}
@@ -3,7 +3,9 @@
 package process
 
 import (
+	"fmt"
 	"os"
+	"os/exec"
 	"testing"
 	"time"
 )
@@ -19,3 +21,47 @@ func TestStopInvalidPID(t *testing.T) {
 		t.Fatalf("Stop invalid pid error: %v", err)
 	}
 }
+
+func TestIdentityDetectsReusedPID(t *testing.T) {
+	id := Lookup(os.Getpid())
+	if !id.Alive() {
+		t.Fatalf("expected current process identity to be alive: %+v", id)
+	}
+	reused := id
+	reused.StartTime++
+	if reused.Alive() {
+		t.Fatalf("expected identity with different start time to be rejected")
+	}
+	if err := reused.Stop(time.Second); err != nil {
+		t.Fatalf("Stop on mismatched identity error: %v", err)
+	}
+}
+
+func TestStopKillsProcessGroup(t *testing.T) {
+	// The shell leads the group; the backgrounded sleep is its child.
+	cmd := exec.Command("sh", "-c", "sleep 30 & echo $!; wait")
+	Detach(cmd)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("StdoutPipe error: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	var child int
+	if _, err := fmt.Fscan(out, &child); err != nil {
+		t.Fatalf("read child pid: %v", err)
+	}
+	go func() { _ = cmd.Wait() }()
+
+	if err := Stop(cmd.Process.Pid, 2*time.Second); err != nil {
+		t.Fatalf("Stop error: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for IsAlive(child) && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if IsAlive(child) {
+		t.Fatalf("child %d survived Stop of its group leader", child)
+	}
+}
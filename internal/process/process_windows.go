@@ -6,9 +6,19 @@ import (
 	"fmt"
 	"os/exec"
 	"strings"
+	"syscall"
 	"time"
 )
 
+// Detach makes cmd start in a new process group. Stop already ends the whole
+// tree through taskkill /T.
+func Detach(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+}
+
 func IsAlive(pid int) bool {
 	if pid <= 0 {
 		return false
@@ -24,11 +34,26 @@ func IsAlive(pid int) bool {
 	return strings.Contains(text, fmt.Sprintf(","+"%d", pid)) || strings.Contains(text, fmt.Sprintf("\"%d\"", pid))
 }
 
+// Terminate asks pid to exit without waiting for it.
+func Terminate(pid int) error {
+	if pid <= 0 {
+		return nil
+	}
+	if err := exec.Command("taskkill", "/PID", fmt.Sprintf("%d", pid), "/T").Run(); err != nil {
+		return fmt.Errorf("failed to terminate pid %d: %w", pid, err)
+	}
+	return nil
+}
+
 func Stop(pid int, grace time.Duration) error {
 	if pid <= 0 || !IsAlive(pid) {
 		return nil
 	}
-	_ = grace
+	return stop(pid, grace, func() bool { return IsAlive(pid) })
+}
+
+func stop(pid int, grace time.Duration, alive func() bool) error {
+	_, _ = grace, alive
 	if err := exec.Command("taskkill", "/PID", fmt.Sprintf("%d", pid), "/T").Run(); err == nil {
 		return nil
 	}
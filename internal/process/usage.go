@@ -0,0 +1,16 @@
+package process
+
+import "errors"
+
+// ErrUsageUnsupported is returned by TreeUsage where the platform offers no
+// cheap way to read per-process usage.
+var ErrUsageUnsupported = errors.New("process usage is not supported on this platform")
+
+// Usage is the combined resource usage of a process and all its descendants.
+type Usage struct {
+	Processes  int
+	CPUSeconds float64
+	CPUPercent float64
+	RSSBytes   uint64
+	OpenFDs    int
+}
@@ -0,0 +1,100 @@
+//go:build linux
+
+package process
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// clockTicks is USER_HZ, which the kernel fixes at 100 on every architecture
+// Go supports.
+const clockTicks = 100
+
+type procStat struct {
+	ppid  int
+	ticks uint64
+	rss   uint64
+}
+
+// TreeUsage sums the usage of pid and its descendants from /proc. CPU percent
+// is measured over sample, and is relative to one CPU.
+func TreeUsage(pid int, sample time.Duration) (Usage, error) {
+	before, err := readProcStats()
+	if err != nil {
+		return Usage{}, err
+	}
+	tree := descendants(before, pid)
+	if len(tree) == 0 {
+		return Usage{}, fmt.Errorf("process %d not found", pid)
+	}
+
+	var usage Usage
+	var startTicks uint64
+	for _, p := range tree {
+		startTicks += before[p].ticks
+	}
+	if sample > 0 {
+		time.Sleep(sample)
+		if after, err := readProcStats(); err == nil {
+			before, tree = after, descendants(after, pid)
+		}
+	}
+	var endTicks uint64
+	for _, p := range tree {
+		st := before[p]
+		endTicks += st.ticks
+		usage.RSSBytes += st.rss * uint64(os.Getpagesize())
+		if fds, err := os.ReadDir(fmt.Sprintf("/proc/%d/fd", p)); err == nil {
+			usage.OpenFDs += len(fds)
+		}
+	}
+	usage.Processes = len(tree)
+	usage.CPUSeconds = float64(endTicks) / clockTicks
+	if sample > 0 && endTicks > startTicks {
+		usage.CPUPercent = float64(endTicks-startTicks) / clockTicks / sample.Seconds() * 100
+	}
+	return usage, nil
+}
+
+func readProcStats() (map[int]procStat, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[int]procStat, len(entries))
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil {
+			continue
+		}
+		stat := string(data)
+		ppid, _ := strconv.Atoi(statField(stat, 4))
+		utime, _ := strconv.ParseUint(statField(stat, 14), 10, 64)
+		stime, _ := strconv.ParseUint(statField(stat, 15), 10, 64)
+		rss, _ := strconv.ParseUint(statField(stat, 24), 10, 64)
+		stats[pid] = procStat{ppid: ppid, ticks: utime + stime, rss: rss}
+	}
+	return stats, nil
+}
+
+func descendants(stats map[int]procStat, root int) []int {
+	if _, ok := stats[root]; !ok {
+		return nil
+	}
+	children := map[int][]int{}
+	for pid, st := range stats {
+		children[st.ppid] = append(children[st.ppid], pid)
+	}
+	out := []int{root}
+	for i := 0; i < len(out); i++ {
+		out = append(out, children[out[i]]...)
+	}
+	return out
+}
@@ -0,0 +1,18 @@
+//go:build linux
+
+package process
+
+import (
+	"os"
+	"testing"
+)
+
+func TestTreeUsageCurrentProcess(t *testing.T) {
+	usage, err := TreeUsage(os.Getpid(), 0)
+	if err != nil {
+		t.Fatalf("TreeUsage error: %v", err)
+	}
+	if usage.Processes < 1 || usage.RSSBytes == 0 || usage.OpenFDs == 0 {
+		t.Fatalf("unexpected usage: %+v", usage)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package process
+
+import "time"
+
+func TreeUsage(pid int, sample time.Duration) (Usage, error) {
+	_, _ = pid, sample
+	return Usage{}, ErrUsageUnsupported
+}
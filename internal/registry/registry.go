@@ -0,0 +1,226 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"maibot/internal/instance"
+)
+
+const (
+	fileName    = "workspaces.json"
+	fileVersion = 1
+	lockName    = "workspaces-registry"
+	lockTimeout = 5 * time.Second
+)
+
+// ErrNotRegistered is returned when an operation names a path that has no
+// entry.
+var ErrNotRegistered = errors.New("workspace is not registered")
+
+// Entry is one registered workspace. Path is the workspace root, the
+// directory that contains .maibot.
+type Entry struct {
+	Path         string    `json:"path"`
+	Name         string    `json:"name"`
+	Tags         []string  `json:"tags,omitempty"`
+	RegisteredAt time.Time `json:"registered_at"`
+}
+
+// HasTag reports whether the entry carries tag.
+func (e Entry) HasTag(tag string) bool {
+	for _, t := range e.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+type file struct {
+	Version    int     `json:"version"`
+	Workspaces []Entry `json:"workspaces"`
+}
+
+// Registry is the list of known workspaces kept in <data_home>/workspaces.json.
+// Every change is made under a lock so concurrent inits do not drop entries.
+type Registry struct {
+	path    string
+	lockDir string
+}
+
+func Open(dataHome string) *Registry {
+	return &Registry{
+		path:    filepath.Join(dataHome, fileName),
+		lockDir: filepath.Join(dataHome, "locks"),
+	}
+}
+
+// List returns the registered workspaces sorted by path.
+func (r *Registry) List() ([]Entry, error) {
+	f, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+	return f.Workspaces, nil
+}
+
+// Register adds e. When the path is already registered its name is updated,
+// e's tags are added to the existing ones and the original registration time
+// is kept.
+func (r *Registry) Register(e Entry) error {
+	e.Path = filepath.Clean(e.Path)
+	if e.RegisteredAt.IsZero() {
+		e.RegisteredAt = time.Now().UTC()
+	}
+	return r.update(func(f *file) bool {
+		for i := range f.Workspaces {
+			if f.Workspaces[i].Path == e.Path {
+				existing := &f.Workspaces[i]
+				existing.Name = e.Name
+				existing.Tags = editTags(existing.Tags, e.Tags, nil)
+				return true
+			}
+		}
+		e.Tags = editTags(nil, e.Tags, nil)
+		f.Workspaces = append(f.Workspaces, e)
+		return true
+	})
+}
+
+// Tag adds and removes tags on the entry for path and returns the updated
+// entry.
+func (r *Registry) Tag(path string, add, remove []string) (Entry, error) {
+	path = filepath.Clean(path)
+	var updated Entry
+	found := false
+	err := r.update(func(f *file) bool {
+		for i := range f.Workspaces {
+			if f.Workspaces[i].Path == path {
+				f.Workspaces[i].Tags = editTags(f.Workspaces[i].Tags, add, remove)
+				updated, found = f.Workspaces[i], true
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return Entry{}, err
+	}
+	if !found {
+		return Entry{}, fmt.Errorf("%w: %s", ErrNotRegistered, path)
+	}
+	return updated, nil
+}
+
+// Deregister removes the entry for path. Removing an unknown path is not an
+// error.
+func (r *Registry) Deregister(path string) error {
+	path = filepath.Clean(path)
+	_, err := r.Prune(func(e Entry) bool { return e.Path != path })
+	return err
+}
+
+// Prune drops every entry for which keep returns false and returns the
+// dropped entries.
+func (r *Registry) Prune(keep func(Entry) bool) ([]Entry, error) {
+	var dropped []Entry
+	err := r.update(func(f *file) bool {
+		kept := f.Workspaces[:0]
+		for _, e := range f.Workspaces {
+			if keep(e) {
+				kept = append(kept, e)
+			} else {
+				dropped = append(dropped, e)
+			}
+		}
+		f.Workspaces = kept
+		return len(dropped) > 0
+	})
+	return dropped, err
+}
+
+// update applies mutate under the registry lock and writes the result when
+// mutate reports a change.
+func (r *Registry) update(mutate func(*file) bool) error {
+	lock, err := instance.AcquireLock(r.lockDir, lockName, lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = lock.Release() }()
+
+	f, err := r.read()
+	if err != nil {
+		return err
+	}
+	if !mutate(&f) {
+		return nil
+	}
+	return r.write(f)
+}
+
+func (r *Registry) read() (file, error) {
+	f := file{Version: fileVersion, Workspaces: []Entry{}}
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return f, nil
+		}
+		return f, err
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return f, err
+	}
+	if f.Workspaces == nil {
+		f.Workspaces = []Entry{}
+	}
+	sort.Slice(f.Workspaces, func(i, j int) bool { return f.Workspaces[i].Path < f.Workspaces[j].Path })
+	return f, nil
+}
+
+func (r *Registry) write(f file) error {
+	f.Version = fileVersion
+	sort.Slice(f.Workspaces, func(i, j int) bool { return f.Workspaces[i].Path < f.Workspaces[j].Path })
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return err
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// editTags returns tags plus add minus remove, deduplicated and sorted. Blank
+// tags are dropped.
+func editTags(tags, add, remove []string) []string {
+	set := map[string]bool{}
+	for _, t := range append(append([]string{}, tags...), add...) {
+		if t = strings.TrimSpace(t); t != "" {
+			set[t] = true
+		}
+	}
+	for _, t := range remove {
+		delete(set, strings.TrimSpace(t))
+	}
+	if len(set) == 0 {
+		return nil
+	}
+	out := make([]string, 0, len(set))
+	for t := range set {
+		out = append(out, t)
+	}
+	sort.Strings(out)
+	return out
+}
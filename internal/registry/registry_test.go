@@ -0,0 +1,83 @@
+package registry
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRegisterUpsertsByPath(t *testing.T) {
+	reg := Open(t.TempDir())
+	if entries, err := reg.List(); err != nil || len(entries) != 0 {
+		t.Fatalf("List on empty registry = %+v, %v", entries, err)
+	}
+	ws := filepath.Join(t.TempDir(), "bot")
+	if err := reg.Register(Entry{Path: ws, Name: "one"}); err != nil {
+		t.Fatalf("Register error: %v", err)
+	}
+	if err := reg.Register(Entry{Path: ws + string(filepath.Separator), Name: "two"}); err != nil {
+		t.Fatalf("Register again error: %v", err)
+	}
+	entries, err := reg.List()
+	if err != nil {
+		t.Fatalf("List error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Path != ws || entries[0].Name != "two" || entries[0].RegisteredAt.IsZero() {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+}
+
+func TestDeregisterAndPrune(t *testing.T) {
+	reg := Open(t.TempDir())
+	base := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		if err := reg.Register(Entry{Path: filepath.Join(base, name), Name: name}); err != nil {
+			t.Fatalf("Register %s error: %v", name, err)
+		}
+	}
+	if err := reg.Deregister(filepath.Join(base, "a")); err != nil {
+		t.Fatalf("Deregister error: %v", err)
+	}
+	if err := reg.Deregister(filepath.Join(base, "missing")); err != nil {
+		t.Fatalf("Deregister unknown path error: %v", err)
+	}
+	dropped, err := reg.Prune(func(e Entry) bool { return e.Name != "b" })
+	if err != nil {
+		t.Fatalf("Prune error: %v", err)
+	}
+	if len(dropped) != 1 || dropped[0].Name != "b" {
+		t.Fatalf("unexpected dropped entries: %+v", dropped)
+	}
+	entries, err := reg.List()
+	if err != nil {
+		t.Fatalf("List error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name != "c" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+}
+
+func TestTagsMergeOnRegisterAndEdit(t *testing.T) {
+	reg := Open(t.TempDir())
+	ws := filepath.Join(t.TempDir(), "bot")
+	if err := reg.Register(Entry{Path: ws, Name: "bot", Tags: []string{"prod", " qq "}}); err != nil {
+		t.Fatalf("Register error: %v", err)
+	}
+	if err := reg.Register(Entry{Path: ws, Name: "bot"}); err != nil {
+		t.Fatalf("Register again error: %v", err)
+	}
+	entry, err := reg.Tag(ws, []string{"eu"}, []string{"qq"})
+	if err != nil {
+		t.Fatalf("Tag error: %v", err)
+	}
+	if got := strings.Join(entry.Tags, ","); got != "eu,prod" {
+		t.Fatalf("tags = %q, want eu,prod", got)
+	}
+	if !entry.HasTag("prod") || entry.HasTag("qq") {
+		t.Fatalf("HasTag mismatch for %+v", entry)
+	}
+	if _, err := reg.Tag(filepath.Join(ws, "other"), []string{"x"}, nil); !errors.Is(err, ErrNotRegistered) {
+		t.Fatalf("Tag on unknown path = %v, want ErrNotRegistered", err)
+	}
+}
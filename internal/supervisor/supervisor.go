@@ -0,0 +1,239 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"maibot/internal/logging"
+	"maibot/internal/process"
+)
+
+type Policy string
+
+const (
+	RestartNever     Policy = "never"
+	RestartOnFailure Policy = "on-failure"
+	RestartAlways    Policy = "always"
+)
+
+// ErrCrashLoop is returned when the child needs more than MaxRestarts
+// restarts within Window.
+var ErrCrashLoop = errors.New("child is crash looping")
+
+type RestartPolicy struct {
+	Policy      Policy
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	MaxRestarts int
+	Window      time.Duration
+}
+
+type Options struct {
+	Command   []string
+	Dir       string
+	Env       []string
+	Stdout    io.Writer
+	Stderr    io.Writer
+	StopGrace time.Duration
+	Restart   RestartPolicy
+	OnStart   func(pid int)
+	OnExit    func(Exit)
+	OnRestart func(restarts int, delay time.Duration)
+}
+
+type Exit struct {
+	PID       int
+	Code      int
+	Signal    string
+	StartedAt time.Time
+	EndedAt   time.Time
+	Stopped   bool
+	Error     string
+}
+
+type Supervisor struct {
+	opts Options
+	log  *logging.Logger
+}
+
+func ParsePolicy(raw string) (Policy, error) {
+	switch p := Policy(strings.ToLower(strings.TrimSpace(raw))); p {
+	case "":
+		return RestartNever, nil
+	case RestartNever, RestartOnFailure, RestartAlways:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown restart policy %q", raw)
+	}
+}
+
+func New(opts Options, logger *logging.Logger) *Supervisor {
+	if opts.StopGrace <= 0 {
+		opts.StopGrace = 5 * time.Second
+	}
+	if opts.Restart.Policy == "" {
+		opts.Restart.Policy = RestartNever
+	}
+	if opts.Restart.Backoff <= 0 {
+		opts.Restart.Backoff = time.Second
+	}
+	if opts.Restart.MaxBackoff < opts.Restart.Backoff {
+		opts.Restart.MaxBackoff = opts.Restart.Backoff
+	}
+	if opts.Restart.MaxRestarts <= 0 {
+		opts.Restart.MaxRestarts = 5
+	}
+	if opts.Restart.Window <= 0 {
+		opts.Restart.Window = 10 * time.Minute
+	}
+	return &Supervisor{opts: opts, log: logger}
+}
+
+// Run starts the child and blocks until it is gone for good: it exited and the
+// restart policy declined to restart it, ctx was cancelled, or it crash looped.
+// Cancelling ctx sends a graceful termination request and kills the child
+// after StopGrace.
+func (s *Supervisor) Run(ctx context.Context) (Exit, error) {
+	if len(s.opts.Command) == 0 || strings.TrimSpace(s.opts.Command[0]) == "" {
+		return Exit{}, errors.New("supervisor command is empty")
+	}
+	var recent []time.Time
+	restarts := 0
+	for {
+		exit, err := s.runOnce(ctx)
+		if err != nil {
+			if s.opts.Restart.Policy == RestartNever {
+				return exit, err
+			}
+			s.warnf("child start failed err=%v", err)
+		}
+		if exit.Stopped || !s.shouldRestart(exit) {
+			return exit, nil
+		}
+
+		now := time.Now()
+		kept := recent[:0]
+		for _, at := range recent {
+			if now.Sub(at) < s.opts.Restart.Window {
+				kept = append(kept, at)
+			}
+		}
+		recent = kept
+		if len(recent) >= s.opts.Restart.MaxRestarts {
+			s.warnf("child crash loop restarts=%d window=%s", len(recent), s.opts.Restart.Window)
+			return exit, ErrCrashLoop
+		}
+		recent = append(recent, now)
+		restarts++
+		delay := s.backoff(len(recent))
+		s.infof("child restart scheduled restarts=%d delay=%s", restarts, delay)
+		if s.opts.OnRestart != nil {
+			s.opts.OnRestart(restarts, delay)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			exit.Stopped = true
+			return exit, nil
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *Supervisor) shouldRestart(exit Exit) bool {
+	switch s.opts.Restart.Policy {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return exit.Code != 0 || exit.Error != ""
+	default:
+		return false
+	}
+}
+
+// backoff doubles the delay for every restart still inside the window.
+func (s *Supervisor) backoff(n int) time.Duration {
+	delay := s.opts.Restart.Backoff
+	for i := 1; i < n; i++ {
+		delay *= 2
+		if delay >= s.opts.Restart.MaxBackoff {
+			return s.opts.Restart.MaxBackoff
+		}
+	}
+	return delay
+}
+
+func (s *Supervisor) runOnce(ctx context.Context) (Exit, error) {
+	cmd := exec.CommandContext(ctx, s.opts.Command[0], s.opts.Command[1:]...)
+	cmd.Dir = s.opts.Dir
+	cmd.Env = s.opts.Env
+	cmd.Stdout = s.opts.Stdout
+	cmd.Stderr = s.opts.Stderr
+	cmd.Cancel = func() error {
+		return process.Terminate(cmd.Process.Pid)
+	}
+	cmd.WaitDelay = s.opts.StopGrace
+
+	exit := Exit{StartedAt: time.Now().UTC()}
+	if err := cmd.Start(); err != nil {
+		exit.EndedAt = time.Now().UTC()
+		exit.Code = -1
+		exit.Error = err.Error()
+		return exit, fmt.Errorf("start %s: %w", s.opts.Command[0], err)
+	}
+	exit.PID = cmd.Process.Pid
+	s.infof("child started pid=%d command=%s", exit.PID, strings.Join(s.opts.Command, " "))
+	if s.opts.OnStart != nil {
+		s.opts.OnStart(exit.PID)
+	}
+
+	waitErr := cmd.Wait()
+	exit.EndedAt = time.Now().UTC()
+	exit.Code = -1
+	if cmd.ProcessState != nil {
+		exit.Code = cmd.ProcessState.ExitCode()
+		if ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			exit.Signal = ws.Signal().String()
+		}
+	}
+	exit.Stopped = ctx.Err() != nil
+	if waitErr != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(waitErr, &exitErr) && !exit.Stopped {
+			exit.Error = waitErr.Error()
+		}
+	}
+	if exit.Stopped {
+		s.infof("child stopped pid=%d code=%d", exit.PID, exit.Code)
+	} else if exit.Code == 0 {
+		s.infof("child exited pid=%d code=%d", exit.PID, exit.Code)
+	} else {
+		s.warnf("child exited pid=%d code=%d", exit.PID, exit.Code)
+	}
+	if s.opts.OnExit != nil {
+		s.opts.OnExit(exit)
+	}
+	return exit, nil
+}
+
+func (s *Supervisor) infof(format string, args ...any) {
+	if s.log == nil {
+		return
+	}
+	s.log.Infof(format, args...)
+}
+
+func (s *Supervisor) warnf(format string, args ...any) {
+	if s.log == nil {
+		return
+	}
+	s.log.Warnf(format, args...)
+}
@@ -0,0 +1,123 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunRecordsExitCodeAndOutput(t *testing.T) {
+	var out strings.Builder
+	var hooked Exit
+	sup := New(Options{
+		Command: []string{"sh", "-c", "echo hello; exit 3"},
+		Dir:     t.TempDir(),
+		Stdout:  &out,
+		Stderr:  &out,
+		OnExit:  func(e Exit) { hooked = e },
+	}, nil)
+	exit, err := sup.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	if exit.Code != 3 {
+		t.Fatalf("exit code = %d, want 3", exit.Code)
+	}
+	if exit.Stopped {
+		t.Fatalf("exit marked stopped without cancellation")
+	}
+	if hooked.PID != exit.PID || hooked.Code != 3 {
+		t.Fatalf("OnExit got %+v, want %+v", hooked, exit)
+	}
+	if strings.TrimSpace(out.String()) != "hello" {
+		t.Fatalf("output = %q, want hello", out.String())
+	}
+}
+
+func TestRunStopsChildOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sup := New(Options{
+		Command:   []string{"sleep", "30"},
+		StopGrace: time.Second,
+		OnStart:   func(int) { cancel() },
+	}, nil)
+	started := time.Now()
+	exit, err := sup.Run(ctx)
+	if err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	if !exit.Stopped {
+		t.Fatalf("exit not marked stopped")
+	}
+	if exit.Signal != "terminated" {
+		t.Fatalf("exit signal = %q, want terminated", exit.Signal)
+	}
+	if time.Since(started) > 5*time.Second {
+		t.Fatalf("child was not stopped promptly")
+	}
+}
+
+func TestRunRejectsEmptyCommand(t *testing.T) {
+	if _, err := New(Options{}, nil).Run(context.Background()); err == nil {
+		t.Fatalf("expected error for empty command")
+	}
+}
+
+func TestRunRestartsOnFailureUntilCrashLoop(t *testing.T) {
+	starts := 0
+	var delays []time.Duration
+	sup := New(Options{
+		Command: []string{"sh", "-c", "exit 1"},
+		Restart: RestartPolicy{
+			Policy:      RestartOnFailure,
+			Backoff:     10 * time.Millisecond,
+			MaxBackoff:  25 * time.Millisecond,
+			MaxRestarts: 3,
+			Window:      time.Minute,
+		},
+		OnStart:   func(int) { starts++ },
+		OnRestart: func(_ int, delay time.Duration) { delays = append(delays, delay) },
+	}, nil)
+	_, err := sup.Run(context.Background())
+	if !errors.Is(err, ErrCrashLoop) {
+		t.Fatalf("Run error = %v, want ErrCrashLoop", err)
+	}
+	if starts != 4 {
+		t.Fatalf("starts = %d, want 4 (1 + 3 restarts)", starts)
+	}
+	want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 25 * time.Millisecond}
+	if len(delays) != len(want) {
+		t.Fatalf("delays = %v, want %v", delays, want)
+	}
+	for i := range want {
+		if delays[i] != want[i] {
+			t.Fatalf("delays = %v, want %v", delays, want)
+		}
+	}
+}
+
+func TestRunOnFailureDoesNotRestartCleanExit(t *testing.T) {
+	starts := 0
+	sup := New(Options{
+		Command: []string{"sh", "-c", "exit 0"},
+		Restart: RestartPolicy{Policy: RestartOnFailure, Backoff: time.Millisecond},
+		OnStart: func(int) { starts++ },
+	}, nil)
+	if _, err := sup.Run(context.Background()); err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	if starts != 1 {
+		t.Fatalf("starts = %d, want 1", starts)
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	if p, err := ParsePolicy(" Always "); err != nil || p != RestartAlways {
+		t.Fatalf("ParsePolicy(always) = %q, %v", p, err)
+	}
+	if _, err := ParsePolicy("sometimes"); err == nil {
+		t.Fatalf("expected error for unknown policy")
+	}
+}